@@ -0,0 +1,355 @@
+package webdavfs
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Authenticator adds credentials to the requests the client sends.
+// Implementations must be safe for concurrent use.
+type Authenticator interface {
+	// Authorize adds credentials to req before it is sent
+	Authorize(req *http.Request) error
+
+	// Challenge is called when the server answers a request with 401
+	// Unauthorized. It reports whether the request should be sent again,
+	// with credentials updated from the challenge in resp.
+	Challenge(resp *http.Response) (retry bool, err error)
+}
+
+// BasicAuthenticator implements HTTP Basic authentication (RFC 7617). It is
+// used for Config.Username and Config.Password.
+type BasicAuthenticator struct {
+	Username string
+	Password string
+}
+
+// Authorize implements Authenticator
+func (a *BasicAuthenticator) Authorize(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// Challenge implements Authenticator. The credentials are sent with every
+// request, so a challenge means they were rejected.
+func (a *BasicAuthenticator) Challenge(resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+// BearerAuthenticator implements Bearer token authentication (RFC 6750).
+// It is used for Config.BearerToken.
+type BearerAuthenticator struct {
+	Token string
+}
+
+// Authorize implements Authenticator
+func (a *BearerAuthenticator) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// Challenge implements Authenticator. The token is sent with every request,
+// so a challenge means it was rejected.
+func (a *BearerAuthenticator) Challenge(resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+// DigestAuthenticator implements HTTP Digest authentication (RFC 7616) with
+// the MD5 and SHA-256 algorithms and qop=auth. The first request is sent
+// without credentials; once the server has issued a challenge, later
+// requests are authorized up front with an incrementing nonce count.
+type DigestAuthenticator struct {
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32 // Requests sent with the current nonce
+}
+
+// digestChallenge holds the parameters of a Digest WWW-Authenticate header
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // Upper case, e.g. "SHA-256" or "MD5-SESS"
+	qop       string // "auth" or "" for the RFC 2069 compatibility mode
+	userhash  bool
+}
+
+// Authorize implements Authenticator
+func (a *DigestAuthenticator) Authorize(req *http.Request) error {
+	a.mu.Lock()
+	ch := a.challenge
+	if ch == nil {
+		a.mu.Unlock()
+		return nil
+	}
+	a.nc++
+	nc := a.nc
+	a.mu.Unlock()
+
+	cnonce, err := newCnonce()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", ch.authorization(a.Username, a.Password, req.Method, req.URL.RequestURI(), nc, cnonce))
+	return nil
+}
+
+// Challenge implements Authenticator. It retries with a new challenge
+// unless the server rejected credentials computed for a current nonce.
+func (a *DigestAuthenticator) Challenge(resp *http.Response) (bool, error) {
+	var ch *digestChallenge
+	var stale bool
+	for _, c := range parseChallenges(resp.Header.Values("WWW-Authenticate")) {
+		if !strings.EqualFold(c.scheme, "Digest") {
+			continue
+		}
+		parsed, ok := newDigestChallenge(c.params)
+		if ok && (ch == nil || digestStrength(parsed.algorithm) > digestStrength(ch.algorithm)) {
+			ch = parsed
+			stale = strings.EqualFold(c.params["stale"], "true")
+		}
+	}
+	if ch == nil {
+		return false, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sentCredentials := resp.Request != nil && resp.Request.Header.Get("Authorization") != ""
+	if sentCredentials && !stale && a.challenge != nil && a.challenge.nonce == ch.nonce {
+		// The credentials themselves were rejected
+		return false, nil
+	}
+	a.challenge = ch
+	a.nc = 0
+	return true, nil
+}
+
+// newDigestChallenge validates the parameters of a Digest challenge
+func newDigestChallenge(params map[string]string) (*digestChallenge, bool) {
+	ch := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: strings.ToUpper(params["algorithm"]),
+		userhash:  strings.EqualFold(params["userhash"], "true"),
+	}
+	if ch.algorithm == "" {
+		ch.algorithm = "MD5"
+	}
+	if ch.nonce == "" || digestStrength(ch.algorithm) == 0 {
+		return nil, false
+	}
+
+	if qop, ok := params["qop"]; ok {
+		for _, q := range strings.Split(qop, ",") {
+			if strings.EqualFold(strings.TrimSpace(q), "auth") {
+				ch.qop = "auth"
+			}
+		}
+		if ch.qop == "" {
+			// Only auth-int is offered, which would require hashing the body
+			return nil, false
+		}
+	}
+	return ch, true
+}
+
+// digestStrength ranks the supported algorithms; 0 means unsupported
+func digestStrength(algorithm string) int {
+	switch algorithm {
+	case "MD5", "MD5-SESS":
+		return 1
+	case "SHA-256", "SHA-256-SESS":
+		return 2
+	default:
+		return 0
+	}
+}
+
+// authorization computes the Authorization header for a request
+func (ch *digestChallenge) authorization(username, password, method, uri string, nc uint32, cnonce string) string {
+	newHash := md5.New
+	if strings.HasPrefix(ch.algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(s string) string {
+		return hashHex(newHash(), s)
+	}
+
+	ncValue := fmt.Sprintf("%08x", nc)
+
+	ha1 := h(username + ":" + ch.realm + ":" + password)
+	if strings.HasSuffix(ch.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + ch.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if ch.qop == "" {
+		response = h(ha1 + ":" + ch.nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + ch.nonce + ":" + ncValue + ":" + cnonce + ":" + ch.qop + ":" + ha2)
+	}
+
+	user := username
+	if ch.userhash {
+		user = h(username + ":" + ch.realm)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s, response="%s"`,
+		quoteParam(user), quoteParam(ch.realm), quoteParam(ch.nonce), quoteParam(uri), ch.algorithm, response)
+	if ch.qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s"`, ch.qop, ncValue, cnonce)
+	}
+	if ch.opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%s", quoteParam(ch.opaque))
+	}
+	if ch.userhash {
+		b.WriteString(", userhash=true")
+	}
+	return b.String()
+}
+
+// hashHex returns the hex encoded hash of s
+func hashHex(h hash.Hash, s string) string {
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newCnonce returns a random client nonce
+func newCnonce() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("digest auth: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// quoteParam returns s as a quoted-string
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// authChallenge is one challenge of a WWW-Authenticate header
+type authChallenge struct {
+	scheme string
+	params map[string]string // Keys in lower case
+}
+
+// errMalformedChallenge is returned by the challenge tokenizer on syntax
+// errors; the challenges parsed so far are kept
+var errMalformedChallenge = errors.New("malformed WWW-Authenticate header")
+
+// parseChallenges parses WWW-Authenticate header values, each of which may
+// hold several comma-separated challenges:
+//
+//	Digest realm="r", nonce="n", Basic realm="r"
+func parseChallenges(values []string) []authChallenge {
+	var challenges []authChallenge
+	for _, v := range values {
+		p := challengeParser{s: v}
+		challenges = append(challenges, p.parse()...)
+	}
+	return challenges
+}
+
+// challengeParser tokenizes a WWW-Authenticate header value
+type challengeParser struct {
+	s string
+	i int
+}
+
+func (p *challengeParser) parse() []authChallenge {
+	var challenges []authChallenge
+	for {
+		p.skip(" \t,")
+		scheme := p.token()
+		if scheme == "" {
+			return challenges
+		}
+		ch := authChallenge{scheme: scheme, params: make(map[string]string)}
+
+		for {
+			p.skip(" \t")
+			start := p.i
+			name := p.token()
+			p.skip(" \t")
+			if name == "" || !p.consume('=') {
+				// Not a parameter, so the next challenge starts here
+				p.i = start
+				break
+			}
+			p.skip(" \t")
+			value, err := p.value()
+			if err != nil {
+				return append(challenges, ch)
+			}
+			ch.params[strings.ToLower(name)] = value
+			p.skip(" \t")
+			if !p.consume(',') {
+				break
+			}
+		}
+		challenges = append(challenges, ch)
+	}
+}
+
+// token reads an HTTP token
+func (p *challengeParser) token() string {
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(" \t,=\"", rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// value reads a token or a quoted-string
+func (p *challengeParser) value() (string, error) {
+	if !p.consume('"') {
+		return p.token(), nil
+	}
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.i < len(p.s) {
+				b.WriteByte(p.s[p.i])
+				p.i++
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errMalformedChallenge
+}
+
+func (p *challengeParser) skip(chars string) {
+	for p.i < len(p.s) && strings.IndexByte(chars, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *challengeParser) consume(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package webdavfs
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// digestServer is a WebDAV server protected by Digest authentication
+type digestServer struct {
+	*httptest.Server
+	algorithm string
+
+	mu         sync.Mutex
+	nonce      int
+	challenges int
+	seen       map[string]bool // nonce:nc pairs already used
+}
+
+func newDigestServer(t *testing.T, algorithm string) *digestServer {
+	t.Helper()
+	s := &digestServer{algorithm: algorithm, nonce: 1, seen: make(map[string]bool)}
+	handler := &webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, stale := s.verify(r); !ok {
+			s.challenge(w, stale)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rotateNonce makes the current nonce stale
+func (s *digestServer) rotateNonce() {
+	s.mu.Lock()
+	s.nonce++
+	s.mu.Unlock()
+}
+
+func (s *digestServer) challenge(w http.ResponseWriter, stale bool) {
+	s.mu.Lock()
+	s.challenges++
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+	s.mu.Unlock()
+
+	w.Header().Add("WWW-Authenticate", `Basic realm="webdav"`)
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="webdav", qop="auth, auth-int", algorithm=%s, nonce="%s", opaque="xyz", stale=%v`, s.algorithm, nonce, stale))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// verify checks the Digest credentials of r for user "alice", password
+// "secret"; stale reports a valid response for an outdated nonce
+func (s *digestServer) verify(r *http.Request) (ok, stale bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return false, false
+	}
+	chs := parseChallenges([]string{auth})
+	if len(chs) != 1 {
+		return false, false
+	}
+	p := chs[0].params
+
+	h := func(v string) string {
+		if s.algorithm == "SHA-256" {
+			return fmt.Sprintf("%x", sha256.Sum256([]byte(v)))
+		}
+		return fmt.Sprintf("%x", md5.Sum([]byte(v)))
+	}
+	ha1 := h("alice:webdav:secret")
+	ha2 := h(r.Method + ":" + p["uri"])
+	want := h(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	if p["username"] != "alice" || p["response"] != want || p["uri"] != r.URL.RequestURI() || p["opaque"] != "xyz" {
+		return false, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p["nonce"] != fmt.Sprintf("nonce-%d", s.nonce) {
+		return false, true
+	}
+	key := p["nonce"] + ":" + p["nc"]
+	if s.seen[key] {
+		return false, false // Replayed nonce count
+	}
+	s.seen[key] = true
+	return true, false
+}
+
+func TestDigestAuthenticator(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			server := newDigestServer(t, algorithm)
+
+			fs, err := New(&Config{
+				URL:  server.URL,
+				Auth: &DigestAuthenticator{Username: "alice", Password: "secret"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create filesystem: %v", err)
+			}
+
+			if err := fs.WriteFile("/secret.txt", []byte("classified"), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			for i := 0; i < 3; i++ {
+				got, err := fs.ReadFile("/secret.txt")
+				if err != nil || string(got) != "classified" {
+					t.Fatalf("ReadFile() = %q, %v", got, err)
+				}
+			}
+			if server.challenges != 1 {
+				t.Errorf("server issued %d challenges, want 1", server.challenges)
+			}
+
+			// A stale nonce is renewed transparently, replaying the body
+			server.rotateNonce()
+			if err := fs.client.put(fs.context(), "/secret.txt", strings.NewReader("declassified")); err != nil {
+				t.Fatalf("put() after nonce rotation error = %v", err)
+			}
+			if got, err := fs.ReadFile("/secret.txt"); err != nil || string(got) != "declassified" {
+				t.Errorf("ReadFile() = %q, %v; want %q", got, err, "declassified")
+			}
+		})
+	}
+}
+
+func TestDigestAuthenticator_WrongPassword(t *testing.T) {
+	server := newDigestServer(t, "SHA-256")
+
+	fs, err := New(&Config{
+		URL:  server.URL,
+		Auth: &DigestAuthenticator{Username: "alice", Password: "wrong"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if _, err := fs.Stat("/"); err == nil {
+		t.Error("Stat() with wrong password expected error")
+	}
+	if server.challenges != 2 {
+		t.Errorf("server issued %d challenges, want 2 (no retry loop)", server.challenges)
+	}
+}
+
+func TestDigestChallenge_RFC7616Example(t *testing.T) {
+	// Example from RFC 7616, section 3.9.1
+	for _, tt := range []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	} {
+		ch := &digestChallenge{
+			realm:     "http-auth@example.org",
+			nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			opaque:    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+			algorithm: tt.algorithm,
+			qop:       "auth",
+		}
+		header := ch.authorization("Mufasa", "Circle of Life", "GET", "/dir/index.html", 1,
+			"f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+		if !strings.Contains(header, `response="`+tt.response+`"`) {
+			t.Errorf("%s: header = %s, want response %s", tt.algorithm, header, tt.response)
+		}
+		if !strings.Contains(header, "nc=00000001") {
+			t.Errorf("%s: header = %s, want nc=00000001", tt.algorithm, header)
+		}
+	}
+}
+
+func TestParseChallenges(t *testing.T) {
+	chs := parseChallenges([]string{
+		`Basic realm="simple", Digest realm="a \"quoted\" realm", qop="auth,auth-int", nonce=abc, algorithm=SHA-256`,
+		`Bearer`,
+	})
+	if len(chs) != 3 {
+		t.Fatalf("parsed %d challenges, want 3: %+v", len(chs), chs)
+	}
+	if chs[0].scheme != "Basic" || chs[0].params["realm"] != "simple" {
+		t.Errorf("challenge 0 = %+v", chs[0])
+	}
+	d := chs[1]
+	if d.scheme != "Digest" || d.params["realm"] != `a "quoted" realm` || d.params["nonce"] != "abc" || d.params["algorithm"] != "SHA-256" {
+		t.Errorf("challenge 1 = %+v", d)
+	}
+	if chs[2].scheme != "Bearer" {
+		t.Errorf("challenge 2 = %+v", chs[2])
+	}
+
+	if _, ok := newDigestChallenge(map[string]string{"nonce": "n", "qop": "auth-int"}); ok {
+		t.Error("challenge offering only auth-int should be rejected")
+	}
+	if _, ok := newDigestChallenge(map[string]string{"nonce": "n", "algorithm": "SHA-512-256"}); ok {
+		t.Error("challenge with unsupported algorithm should be rejected")
+	}
+}
+
+func TestConfig_AuthExclusive(t *testing.T) {
+	_, err := New(&Config{
+		URL:      "http://example.com",
+		Username: "user",
+		Auth:     &DigestAuthenticator{Username: "user"},
+	})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("New() error = %v, want *ConfigError", err)
+	}
+}
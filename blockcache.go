@@ -0,0 +1,281 @@
+package webdavfs
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BlockCacheConfig configures the cache of file content used by
+// File.ReadAt. Content is fetched and cached in aligned blocks, keyed by
+// path and ETag, so a new version of a file never returns stale data.
+type BlockCacheConfig struct {
+	// BlockSize is the unit in which content is fetched and cached
+	// (default: 256 KiB)
+	BlockSize int64
+
+	// MaxBytes bounds the total size of the cached blocks; the least
+	// recently used blocks are evicted first (default: 64 MiB)
+	MaxBytes int64
+}
+
+// setDefaults sets default values for the cache configuration
+func (bc *BlockCacheConfig) setDefaults() {
+	if bc.BlockSize == 0 {
+		bc.BlockSize = 256 << 10
+	}
+	if bc.MaxBytes == 0 {
+		bc.MaxBytes = 64 << 20
+	}
+}
+
+// validate checks if the cache configuration is valid
+func (bc *BlockCacheConfig) validate() error {
+	if bc.BlockSize < 0 {
+		return &ConfigError{Field: "BlockCache.BlockSize", Reason: "must not be negative"}
+	}
+	if bc.MaxBytes < 0 {
+		return &ConfigError{Field: "BlockCache.MaxBytes", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// blockKey identifies a block of one version of a file
+type blockKey struct {
+	path  string
+	etag  string
+	index int64
+}
+
+// blockFetch is a block being downloaded. Readers needing the same block
+// wait for it instead of requesting it again.
+type blockFetch struct {
+	done      chan struct{}
+	data      []byte
+	err       error
+	abandoned bool // The downloading reader's context ended; fetch again
+}
+
+// cachedBlock is an entry of the LRU list
+type cachedBlock struct {
+	key  blockKey
+	data []byte
+}
+
+// blockCache caches file content in blocks for ReadAt
+type blockCache struct {
+	blockSize int64
+	maxBytes  int64
+
+	mu       sync.Mutex
+	size     int64
+	blocks   map[blockKey]*list.Element
+	lru      *list.List // Front is most recently used
+	inflight map[blockKey]*blockFetch
+}
+
+// newBlockCache returns a cache for the configuration, or nil if config is
+// nil
+func newBlockCache(config *BlockCacheConfig) *blockCache {
+	if config == nil {
+		return nil
+	}
+	return &blockCache{
+		blockSize: config.BlockSize,
+		maxBytes:  config.MaxBytes,
+		blocks:    make(map[blockKey]*list.Element),
+		lru:       list.New(),
+		inflight:  make(map[blockKey]*blockFetch),
+	}
+}
+
+// readAt fills b from the version etag of the file pathStr, which has the
+// given size. Like io.ReaderAt, it returns an error whenever it reads fewer
+// than len(b) bytes.
+func (bc *blockCache) readAt(ctx context.Context, c *webdavClient, pathStr, etag string, size int64, b []byte, off int64) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(b))
+	if end > size {
+		end = size
+	}
+
+	first, last := off/bc.blockSize, (end-1)/bc.blockSize
+	fetches, owned := bc.acquire(pathStr, etag, first, last)
+	for i := 0; i < len(fetches); {
+		if !owned[i] {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(fetches) && owned[j+1] {
+			j++
+		}
+		bc.fetchRun(ctx, c, pathStr, etag, size, first+int64(i), fetches[i:j+1])
+		i = j + 1
+	}
+
+	n := 0
+	for i, fetch := range fetches {
+		for {
+			select {
+			case <-fetch.done:
+			case <-ctx.Done():
+				return n, &os.PathError{Op: "read", Path: pathStr, Err: ctx.Err()}
+			}
+			if !fetch.abandoned {
+				break
+			}
+			if ctx.Err() != nil {
+				return n, &os.PathError{Op: "read", Path: pathStr, Err: ctx.Err()}
+			}
+			// The reader downloading the block was cancelled, which is no
+			// reason for this one to fail
+			fetch = bc.refetch(ctx, c, pathStr, etag, size, first+int64(i))
+		}
+		if fetch.err != nil {
+			return n, fetch.err
+		}
+
+		blockStart := (first + int64(i)) * bc.blockSize
+		lo := max(off, blockStart) - blockStart
+		hi := min(end, blockStart+bc.blockSize) - blockStart
+		got := copy(b[n:], fetch.data[min(lo, int64(len(fetch.data))):min(hi, int64(len(fetch.data)))])
+		n += got
+		if int64(got) < hi-lo {
+			// The file is shorter than its recorded size
+			break
+		}
+	}
+
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// acquire returns a fetch for each block from first to last. Cached blocks
+// are complete, and blocks already being downloaded by another reader will
+// complete on their own. The others are registered as in flight and marked
+// as owned; the caller must download them.
+func (bc *blockCache) acquire(pathStr, etag string, first, last int64) ([]*blockFetch, []bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	fetches := make([]*blockFetch, 0, last-first+1)
+	owned := make([]bool, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		key := blockKey{path: pathStr, etag: etag, index: i}
+		if elem, ok := bc.blocks[key]; ok {
+			bc.lru.MoveToFront(elem)
+			fetch := &blockFetch{done: make(chan struct{}), data: elem.Value.(*cachedBlock).data}
+			close(fetch.done)
+			fetches = append(fetches, fetch)
+			owned = append(owned, false)
+			continue
+		}
+		if fetch, ok := bc.inflight[key]; ok {
+			fetches = append(fetches, fetch)
+			owned = append(owned, false)
+			continue
+		}
+		fetch := &blockFetch{done: make(chan struct{})}
+		bc.inflight[key] = fetch
+		fetches = append(fetches, fetch)
+		owned = append(owned, true)
+	}
+	return fetches, owned
+}
+
+// refetch returns a new fetch of the block index, downloading it unless
+// another reader already does
+func (bc *blockCache) refetch(ctx context.Context, c *webdavClient, pathStr, etag string, size, index int64) *blockFetch {
+	fetches, owned := bc.acquire(pathStr, etag, index, index)
+	if owned[0] {
+		bc.fetchRun(ctx, c, pathStr, etag, size, index, fetches)
+	}
+	return fetches[0]
+}
+
+// fetchRun downloads the consecutive blocks starting at index start and
+// completes their fetches
+func (bc *blockCache) fetchRun(ctx context.Context, c *webdavClient, pathStr, etag string, size, start int64, run []*blockFetch) {
+	off := start * bc.blockSize
+	length := int64(len(run)) * bc.blockSize
+	if off+length > size {
+		length = size - off
+	}
+
+	data, served, err := readRange(ctx, c, pathStr, off, length)
+	abandoned := err != nil && ctx.Err() != nil
+	if err == nil && served != "" && strings.TrimPrefix(served, "W/") != strings.TrimPrefix(etag, "W/") {
+		// Another version was served, whose bytes must not be mixed with
+		// those of the version read so far
+		err = &PreconditionError{Path: pathStr, ETag: etag}
+	}
+	cacheable := err == nil && (served == "" || served == etag)
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for i, fetch := range run {
+		key := blockKey{path: pathStr, etag: etag, index: start + int64(i)}
+		delete(bc.inflight, key)
+
+		if err != nil {
+			fetch.err = err
+			fetch.abandoned = abandoned
+		} else {
+			lo := int64(i) * bc.blockSize
+			hi := lo + bc.blockSize
+			if lo > int64(len(data)) {
+				lo = int64(len(data))
+			}
+			if hi > int64(len(data)) {
+				hi = int64(len(data))
+			}
+			fetch.data = data[lo:hi:hi]
+			if cacheable {
+				bc.store(key, fetch.data)
+			}
+		}
+		close(fetch.done)
+	}
+}
+
+// store adds a block and evicts the least recently used ones beyond the
+// size bound. bc.mu must be held.
+func (bc *blockCache) store(key blockKey, data []byte) {
+	if _, ok := bc.blocks[key]; ok || int64(len(data)) > bc.maxBytes {
+		return
+	}
+	bc.blocks[key] = bc.lru.PushFront(&cachedBlock{key: key, data: data})
+	bc.size += int64(len(data))
+	for bc.size > bc.maxBytes {
+		back := bc.lru.Back()
+		block := back.Value.(*cachedBlock)
+		bc.lru.Remove(back)
+		delete(bc.blocks, block.key)
+		bc.size -= int64(len(block.data))
+	}
+}
+
+// readRange downloads exactly the requested range, or less at the end of
+// the file, and returns it with the ETag of the version served
+func readRange(ctx context.Context, c *webdavClient, pathStr string, off, length int64) ([]byte, string, error) {
+	body, etag, err := c.getRange(ctx, pathStr, off, length)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+
+	data := make([]byte, length)
+	n, err := io.ReadFull(body, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", &os.PathError{Op: "read", Path: pathStr, Err: contextError(ctx, err)}
+	}
+	return data[:n], etag, nil
+}
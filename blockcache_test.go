@@ -0,0 +1,313 @@
+package webdavfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// newRangeServer returns a WebDAV server that records the Range header of
+// every GET
+func newRangeServer(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var ranges []string
+	handler := &webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := ranges
+		ranges = nil
+		return got
+	}
+}
+
+func TestFile_ReadAtBoundedRange(t *testing.T) {
+	server, ranges := newRangeServer(t)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.WriteFile("/data.bin", []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	f, err := fs.Open("/data.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 3)
+	if n, err := f.ReadAt(b, 4); n != 3 || err != nil || string(b) != "456" {
+		t.Errorf("ReadAt() = %d, %v, %q; want 3, nil, %q", n, err, b[:n], "456")
+	}
+	if got := ranges(); len(got) != 1 || got[0] != "bytes=4-6" {
+		t.Errorf("Range headers = %q, want [bytes=4-6]", got)
+	}
+
+	// A read past the end returns the available bytes and io.EOF
+	b = make([]byte, 5)
+	if n, err := f.ReadAt(b, 8); n != 2 || err != io.EOF || string(b[:n]) != "89" {
+		t.Errorf("ReadAt() at end = %d, %v, %q; want 2, EOF, %q", n, err, b[:n], "89")
+	}
+	if n, err := f.ReadAt(b, 20); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt() beyond end = %d, %v; want 0, EOF", n, err)
+	}
+	if _, err := f.ReadAt(b, -1); err == nil {
+		t.Error("ReadAt() with negative offset expected error")
+	}
+}
+
+func TestFile_ReadAtIgnoredRange(t *testing.T) {
+	content := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/plain.txt</D:href>
+    <D:propstat>
+      <D:prop><D:getcontentlength>10</D:getcontentlength><D:resourcetype/></D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+</D:multistatus>`))
+		case "GET":
+			// Ignore the Range header and send everything
+			w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	f, err := fs.Open("/plain.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 3)
+	if n, err := f.ReadAt(b, 5); n != 3 || err != nil || string(b) != "567" {
+		t.Errorf("ReadAt() = %d, %v, %q; want 3, nil, %q", n, err, b[:n], "567")
+	}
+
+	if _, err := f.Seek(7, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil || string(rest) != "789" {
+		t.Errorf("ReadAll() after Seek = %q, %v; want %q", rest, err, "789")
+	}
+}
+
+func TestFile_ReadAtBlockCache(t *testing.T) {
+	server, ranges := newRangeServer(t)
+
+	fs, err := New(&Config{URL: server.URL, BlockCache: &BlockCacheConfig{BlockSize: 16}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	content := bytes.Repeat([]byte("abcdefghij"), 10) // 100 bytes, 7 blocks
+	if err := fs.WriteFile("/zip.bin", content, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	f, err := fs.Open("/zip.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	// Many small reads through a SectionReader cost one request per block
+	got, err := io.ReadAll(io.NewSectionReader(f, 0, 100))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("ReadAll(SectionReader) = %q, %v", got, err)
+	}
+	if n := len(ranges()); n > 7 {
+		t.Errorf("reading 7 blocks sent %d requests", n)
+	}
+
+	// Cached blocks are reused, also by concurrent readers
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			b := make([]byte, 20)
+			n, err := f.ReadAt(b, off)
+			if err != nil || !bytes.Equal(b[:n], content[off:off+20]) {
+				failures.Add(1)
+			}
+		}(int64(i * 10))
+	}
+	wg.Wait()
+	if failures.Load() > 0 {
+		t.Errorf("%d concurrent ReadAt calls returned wrong data", failures.Load())
+	}
+	if got := ranges(); len(got) != 0 {
+		t.Errorf("cached reads sent requests %q", got)
+	}
+
+	// A new version has a new ETag, so none of the cached blocks apply, and
+	// a read spanning missing blocks fetches them in one request
+	if err := fs.WriteFile("/zip.bin", content[:50], 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	f2, err := fs.Open("/zip.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f2.Close()
+	b := make([]byte, 64)
+	n, err := f2.ReadAt(b, 0)
+	if n != 50 || err != io.EOF || !bytes.Equal(b[:n], content[:50]) {
+		t.Errorf("ReadAt() of new version = %d, %v", n, err)
+	}
+	if got := ranges(); len(got) != 1 || got[0] != "bytes=0-49" {
+		t.Errorf("Range headers = %q, want [bytes=0-49]", got)
+	}
+}
+
+func TestBlockCache_Eviction(t *testing.T) {
+	bc := newBlockCache(&BlockCacheConfig{BlockSize: 4, MaxBytes: 8})
+	for i := int64(0); i < 3; i++ {
+		bc.store(blockKey{path: "/f", etag: `"1"`, index: i}, []byte("abcd"))
+	}
+	if bc.size != 8 || len(bc.blocks) != 2 {
+		t.Errorf("size = %d with %d blocks, want 8 with 2", bc.size, len(bc.blocks))
+	}
+	if _, ok := bc.blocks[blockKey{path: "/f", etag: `"1"`, index: 0}]; ok {
+		t.Error("expected the oldest block to be evicted")
+	}
+}
+
+func TestFile_ReadAtBlockCacheChangedFile(t *testing.T) {
+	server, _ := newRangeServer(t)
+
+	fs, err := New(&Config{URL: server.URL, BlockCache: &BlockCacheConfig{BlockSize: 16}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	content := bytes.Repeat([]byte("abcdefghij"), 10)
+	if err := fs.WriteFile("/zip.bin", content, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	f, err := fs.Open("/zip.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 16)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+
+	// Blocks of the new version must not be mixed with those already read
+	if err := fs.WriteFile("/zip.bin", bytes.ToUpper(content[:90]), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	n, err := f.ReadAt(b, 32)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("ReadAt() of a changed file = %d, %v; want ErrConflict", n, err)
+	}
+	var pe *PreconditionError
+	if !errors.As(err, &pe) || pe.Path != "/zip.bin" {
+		t.Errorf("ReadAt() error = %#v, want a *PreconditionError for /zip.bin", err)
+	}
+}
+
+func TestFile_ReadAtBlockCacheCancelledFetch(t *testing.T) {
+	mem := webdav.NewMemFS()
+	handler := &webdav.Handler{FileSystem: mem, LockSystem: webdav.NewMemLS()}
+	var gets atomic.Int32
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first download hangs until its reader gives up
+		if r.Method == "GET" && gets.Add(1) == 1 {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL, BlockCache: &BlockCacheConfig{BlockSize: 16}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	content := bytes.Repeat([]byte("abcdefghij"), 10)
+	if err := fs.WriteFile("/zip.bin", content, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	owner, err := fs.WithContext(ctx).Open("/zip.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer owner.Close()
+	waiter, err := fs.Open("/zip.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer waiter.Close()
+
+	ownerDone := make(chan error, 1)
+	go func() {
+		_, err := owner.ReadAt(make([]byte, 16), 0)
+		ownerDone <- err
+	}()
+	<-started
+
+	waiterDone := make(chan error, 1)
+	b := make([]byte, 16)
+	go func() {
+		_, err := waiter.ReadAt(b, 0)
+		waiterDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // Let the waiter join the fetch
+	cancel()
+
+	if err := <-ownerDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled ReadAt() error = %v, want context.Canceled", err)
+	}
+	select {
+	case err := <-waiterDone:
+		if err != nil || !bytes.Equal(b, content[:16]) {
+			t.Errorf("waiting ReadAt() = %q, %v; want the block", b, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting ReadAt() did not finish")
+	}
+}
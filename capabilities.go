@@ -0,0 +1,190 @@
+package webdavfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNotWebDAV is matched by the error New returns when Config.VerifyOnConnect
+// is set and the server does not advertise WebDAV support
+var ErrNotWebDAV = errors.New("server does not support WebDAV")
+
+// Capabilities describes the features a server advertises in its response
+// to OPTIONS
+type Capabilities struct {
+	// Classes lists the DAV compliance classes and extensions from the DAV
+	// header, such as "1", "2", "3" or "access-control"
+	Classes []string
+
+	// Methods lists the methods from the Allow header, in upper case. It is
+	// nil if the server sent no Allow header.
+	Methods []string
+
+	// AcceptRanges reports whether the server advertises byte range
+	// requests (Accept-Ranges: bytes)
+	AcceptRanges bool
+
+	// Server is the Server header, e.g. "Apache/2.4.57 (Unix)"
+	Server string
+
+	// SabreVersion is the X-Sabre-Version header, sent by servers built on
+	// sabre/dav such as Nextcloud and ownCloud
+	SabreVersion string
+
+	// Nextcloud reports whether the server advertises Nextcloud or ownCloud
+	// extensions, such as the "nextcloud-checksum-update" class
+	Nextcloud bool
+
+	// Header holds all response headers, for vendor headers not covered by
+	// the fields above
+	Header http.Header
+}
+
+// HasClass reports whether the server advertises the DAV compliance class
+// or extension
+func (c *Capabilities) HasClass(class string) bool {
+	for _, cl := range c.Classes {
+		if strings.EqualFold(cl, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the server lists method in its Allow header. If
+// the server sent no Allow header, every method is assumed to be allowed.
+func (c *Capabilities) Allows(method string) bool {
+	if c.Methods == nil {
+		return true
+	}
+	for _, m := range c.Methods {
+		if m == strings.ToUpper(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsLocking reports whether the server implements WebDAV locking
+// (class 2)
+func (c *Capabilities) SupportsLocking() bool {
+	return c.HasClass("2") && c.Allows("LOCK")
+}
+
+// Capabilities returns the features advertised by the server. The server is
+// asked with OPTIONS on the first call; the result is cached for the
+// lifetime of the FileSystem. A failed discovery is not cached.
+func (fs *FileSystem) Capabilities() (*Capabilities, error) {
+	return fs.client.capabilities(fs.context())
+}
+
+// capabilityCache holds the result of capability discovery
+type capabilityCache struct {
+	mu   sync.Mutex
+	caps *Capabilities
+}
+
+// known returns the capabilities if they were already discovered
+func (cc *capabilityCache) known() *Capabilities {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.caps
+}
+
+// capabilities returns the server's capabilities, discovering them with
+// OPTIONS on the base URL if needed
+func (c *webdavClient) capabilities(ctx context.Context) (*Capabilities, error) {
+	if caps := c.caps.known(); caps != nil {
+		return caps, nil
+	}
+
+	resp, err := c.doRequest(ctx, "OPTIONS", "/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, c.statusError(ctx, resp.StatusCode, "/")
+	}
+	caps := parseCapabilities(resp.Header)
+
+	c.caps.mu.Lock()
+	defer c.caps.mu.Unlock()
+	if c.caps.caps == nil {
+		c.caps.caps = caps
+	}
+	return c.caps.caps, nil
+}
+
+// parseCapabilities extracts the capabilities from the headers of an
+// OPTIONS response
+func parseCapabilities(h http.Header) *Capabilities {
+	caps := &Capabilities{
+		Classes:      splitHeaderList(h.Values("DAV")),
+		AcceptRanges: strings.EqualFold(strings.TrimSpace(h.Get("Accept-Ranges")), "bytes"),
+		Server:       h.Get("Server"),
+		SabreVersion: h.Get("X-Sabre-Version"),
+		Header:       h.Clone(),
+	}
+	if values := h.Values("Allow"); values != nil {
+		caps.Methods = splitHeaderList(values)
+		for i, m := range caps.Methods {
+			caps.Methods[i] = strings.ToUpper(m)
+		}
+	}
+	for _, class := range caps.Classes {
+		lower := strings.ToLower(class)
+		if strings.HasPrefix(lower, "nextcloud-") || strings.HasPrefix(lower, "nc-") || strings.HasPrefix(lower, "oc-") {
+			caps.Nextcloud = true
+		}
+	}
+	return caps
+}
+
+// splitHeaderList splits comma-separated header values into their trimmed,
+// non-empty elements
+func splitHeaderList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			if elem = strings.TrimSpace(elem); elem != "" {
+				list = append(list, elem)
+			}
+		}
+	}
+	return list
+}
+
+// verifyWebDAV checks that the server advertises WebDAV class 1
+func (c *webdavClient) verifyWebDAV(ctx context.Context) error {
+	caps, err := c.capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if !caps.HasClass("1") {
+		return &os.PathError{Op: "options", Path: "/", Err: ErrNotWebDAV}
+	}
+	return nil
+}
+
+// unsupported reports whether a request failed with statusCode because the
+// server does not implement method, either saying so with 405 or 501 or by
+// leaving it out of the Allow header
+func (c *webdavClient) unsupported(ctx context.Context, statusCode int, method string) bool {
+	if statusCode == http.StatusMethodNotAllowed || statusCode == http.StatusNotImplemented {
+		return true
+	}
+	caps, err := c.capabilities(ctx)
+	return err == nil && !caps.Allows(method)
+}
+
+// errLockingUnsupported reports that the server does not implement locking
+func errLockingUnsupported(pathStr string) error {
+	return &os.PathError{Op: "lock", Path: pathStr, Err: fmt.Errorf("server does not support WebDAV locking: %w", errors.ErrUnsupported)}
+}
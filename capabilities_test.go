@@ -0,0 +1,186 @@
+package webdavfs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestCapabilities(t *testing.T) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	var options atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			options.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL, VerifyOnConnect: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	caps, err := fs.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities() error = %v", err)
+	}
+	if !caps.HasClass("1") || !caps.HasClass("2") {
+		t.Errorf("Classes = %v, want 1 and 2", caps.Classes)
+	}
+	if !caps.Allows("propfind") || !caps.SupportsLocking() {
+		t.Errorf("Methods = %v, want PROPFIND and LOCK", caps.Methods)
+	}
+
+	if _, err := fs.Capabilities(); err != nil {
+		t.Fatalf("Capabilities() error = %v", err)
+	}
+	if got := options.Load(); got != 1 {
+		t.Errorf("server received %d OPTIONS requests, want 1", got)
+	}
+}
+
+func TestCapabilities_VendorHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("DAV", "1, 3, extended-mkcol, access-control")
+		w.Header().Add("DAV", "nextcloud-checksum-update")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, DELETE, PROPFIND, PUT, PROPPATCH, COPY, MOVE, REPORT")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("X-Sabre-Version", "4.6.0")
+		w.Header().Set("Server", "nginx")
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	caps, err := fs.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities() error = %v", err)
+	}
+
+	want := []string{"1", "3", "extended-mkcol", "access-control", "nextcloud-checksum-update"}
+	if !reflect.DeepEqual(caps.Classes, want) {
+		t.Errorf("Classes = %v, want %v", caps.Classes, want)
+	}
+	if !caps.AcceptRanges || caps.SabreVersion != "4.6.0" || !caps.Nextcloud || caps.Server != "nginx" {
+		t.Errorf("Capabilities = %+v", caps)
+	}
+	if caps.Allows("LOCK") || caps.SupportsLocking() {
+		t.Error("SupportsLocking() = true for a server without class 2")
+	}
+	if !caps.Allows("copy") {
+		t.Error("Allows(copy) = false")
+	}
+}
+
+func TestConfig_VerifyOnConnect(t *testing.T) {
+	// An ordinary web server answers OPTIONS without a DAV header
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	}))
+	defer plain.Close()
+
+	if _, err := New(&Config{URL: plain.URL, VerifyOnConnect: true}); !errors.Is(err, ErrNotWebDAV) {
+		t.Errorf("New() error = %v, want ErrNotWebDAV", err)
+	}
+	if _, err := New(&Config{URL: plain.URL}); err != nil {
+		t.Errorf("New() without VerifyOnConnect error = %v", err)
+	}
+
+	// A failed discovery is reported and not cached
+	var fail atomic.Bool
+	fail.Store(true)
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	if _, err := New(&Config{URL: flaky.URL, VerifyOnConnect: true}); !os.IsPermission(err) {
+		t.Errorf("New() error = %v, want permission error", err)
+	}
+	fs, err := New(&Config{URL: flaky.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Capabilities(); err == nil {
+		t.Fatal("Capabilities() expected error")
+	}
+	fail.Store(false)
+	if caps, err := fs.Capabilities(); err != nil || !caps.HasClass("1") {
+		t.Errorf("Capabilities() = %v, %v after the server recovered", caps, err)
+	}
+}
+
+func TestLock_Unsupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			w.Header().Set("DAV", "1")
+			w.Header().Set("Allow", "OPTIONS, GET, PUT, PROPFIND")
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Discovered after the LOCK fails
+	if _, err := fs.Lock("/file.txt", LockOptions{}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Lock() error = %v, want errors.ErrUnsupported", err)
+	}
+	// Known up front once discovered
+	if _, err := fs.Lock("/file.txt", LockOptions{}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Lock() error = %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestChtimes_Capabilities(t *testing.T) {
+	// A server that does not implement PROPPATCH leaves times unchanged
+	noProppatch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			w.Header().Set("DAV", "1")
+			w.Header().Set("Allow", "OPTIONS, GET, PUT, PROPFIND")
+		default:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+	}))
+	defer noProppatch.Close()
+
+	fs, err := New(&Config{URL: noProppatch.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := fs.Chtimes("/file.txt", time.Now(), time.Now()); err != nil {
+		t.Errorf("Chtimes() on a server without PROPPATCH error = %v", err)
+	}
+
+	// A server that does implement it reports real failures
+	server := newLockingServer(t)
+	fs, err = New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := fs.Chtimes("/missing.txt", time.Now(), time.Now()); !os.IsNotExist(err) {
+		t.Errorf("Chtimes() on a missing file error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,403 @@
+package webdavfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxChunkedUploadPasses bounds how many times a chunked upload resumes
+	// after failing to transfer some of its chunks
+	maxChunkedUploadPasses = 3
+
+	// maxChunks is the highest chunk number the protocol accepts
+	maxChunks = 10000
+)
+
+// chunkedUploadsEnabled reports whether large uploads use the
+// Nextcloud/ownCloud chunked upload protocol
+func (c *webdavClient) chunkedUploadsEnabled() bool {
+	return c.uploadsURL != nil && c.chunkSize > 0
+}
+
+// upload writes size bytes read from src to pathStr if the precondition
+// holds, and returns the new ETag if the server reports one. Uploads larger
+// than one chunk use the chunked upload protocol when it is configured;
+// everything else is sent as a single PUT.
+func (c *webdavClient) upload(ctx context.Context, pathStr string, src io.ReaderAt, size int64, pre precondition) (string, error) {
+	if c.chunkedUploadsEnabled() && size > c.chunkSize {
+		return c.chunkedUpload(ctx, pathStr, src, size, pre)
+	}
+	return c.putCond(ctx, pathStr, io.NewSectionReader(src, 0, size), pre)
+}
+
+// chunkedTransfer is a chunked upload in progress. Following the Nextcloud
+// chunking v2 protocol, the chunks are PUT as numbered members of a
+// collection under the uploads URL, and a final MOVE of its ".file" member
+// assembles them at the destination.
+type chunkedTransfer struct {
+	c           *webdavClient
+	pathStr     string
+	dir         *url.URL // Upload collection
+	destination string   // Absolute URL of the target file
+	total       int64    // Size of the file, or -1 while unknown
+}
+
+// beginChunkedTransfer creates the upload collection for pathStr. If the
+// size of the file is known (total >= 0), it is declared to the server, so
+// that a server enforcing a quota can refuse the upload before any chunk is
+// sent.
+func (c *webdavClient) beginChunkedTransfer(ctx context.Context, pathStr string, total int64) (*chunkedTransfer, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	dest, err := c.buildURL(pathStr)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := *c.uploadsURL
+	dir.Path = path.Join(dir.Path, "webdavfs-"+hex.EncodeToString(id)) + "/"
+
+	t := &chunkedTransfer{
+		c:           c,
+		pathStr:     pathStr,
+		dir:         &dir,
+		destination: dest.String(),
+		total:       total,
+	}
+
+	resp, err := c.doRequestURL(ctx, "MKCOL", t.dir, pathStr, nil, t.headers(map[string]string{
+		"Destination": t.destination,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, httpStatusToOSError(resp.StatusCode, pathStr)
+	}
+
+	return t, nil
+}
+
+// headers adds the declared size of the file to the headers of a request
+// of the transfer
+func (t *chunkedTransfer) headers(headers map[string]string) map[string]string {
+	if t.total >= 0 {
+		headers["OC-Total-Length"] = strconv.FormatInt(t.total, 10)
+	}
+	return headers
+}
+
+// chunkURL returns the URL of the given chunk, numbered from 1
+func (t *chunkedTransfer) chunkURL(part int) *url.URL {
+	u := *t.dir
+	u.Path = path.Join(u.Path, strconv.Itoa(part))
+	return &u
+}
+
+// putChunk uploads one chunk. Failures are reported as *WebDAVError so that
+// the caller can decide whether to resume.
+func (t *chunkedTransfer) putChunk(ctx context.Context, part int, data io.Reader) error {
+	resp, err := t.c.doRequestURL(ctx, "PUT", t.chunkURL(part), t.pathStr, data, t.headers(map[string]string{
+		"Content-Type": "application/octet-stream",
+		"Destination":  t.destination,
+	}))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &WebDAVError{
+			StatusCode: resp.StatusCode,
+			Method:     "PUT",
+			Path:       t.chunkURL(part).Path,
+			Message:    string(body),
+		}
+	}
+
+	return nil
+}
+
+// uploadedChunks lists the chunks the server already holds, keyed by number
+// with their sizes
+func (t *chunkedTransfer) uploadedChunks(ctx context.Context) (map[int]int64, error) {
+	resp, err := t.c.doRequestURL(ctx, "PROPFIND", t.dir, t.pathStr, strings.NewReader(buildPropfindBody()), map[string]string{
+		"Content-Type": "application/xml",
+		"Depth":        "1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, httpStatusToOSError(resp.StatusCode, t.pathStr)
+	}
+
+	ms, err := parseMultistatus(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make(map[int]int64)
+	for _, r := range ms.Responses {
+		part, err := strconv.Atoi(path.Base(strings.TrimSuffix(r.href(), "/")))
+		if err != nil {
+			continue // The collection itself
+		}
+		size, _ := strconv.ParseInt(r.props().GetContentLength, 10, 64)
+		chunks[part] = size
+	}
+
+	return chunks, nil
+}
+
+// assemble moves the uploaded chunks into place as the destination file and
+// returns its new ETag, if the server reports one. The precondition is
+// checked just before, as the MOVE cannot carry it for the destination.
+func (t *chunkedTransfer) assemble(ctx context.Context, total int64, pre precondition) (string, error) {
+	defer t.c.invalidate(t.pathStr)
+
+	if err := t.c.checkPrecondition(ctx, t.pathStr, pre); err != nil {
+		return "", err
+	}
+
+	u := *t.dir
+	u.Path = path.Join(u.Path, ".file")
+
+	headers := map[string]string{
+		"Destination":     t.destination,
+		"Overwrite":       "T",
+		"OC-Total-Length": strconv.FormatInt(total, 10),
+	}
+	headers = t.c.setIfHeader(headers, t.pathStr)
+
+	resp, err := t.c.doRequestURL(ctx, "MOVE", &u, t.pathStr, nil, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return "", t.c.statusError(ctx, resp.StatusCode, t.pathStr)
+	}
+
+	// Nextcloud reports the ETag of the assembled file in OC-ETag
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = resp.Header.Get("OC-ETag")
+	}
+	return normalizeETag(etag), nil
+}
+
+// abort removes the upload collection and any chunks in it
+func (t *chunkedTransfer) abort(ctx context.Context) {
+	resp, err := t.c.doRequestURL(ctx, "DELETE", t.dir, t.pathStr, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// chunkedUpload uploads size bytes from src to pathStr in chunks. When some
+// chunks fail with a server or network error, the upload resumes by asking
+// the server which chunks it already has and sending only the rest.
+func (c *webdavClient) chunkedUpload(ctx context.Context, pathStr string, src io.ReaderAt, size int64, pre precondition) (string, error) {
+	// Fail early rather than after transferring everything
+	if err := c.checkPrecondition(ctx, pathStr, pre); err != nil {
+		return "", err
+	}
+
+	t, err := c.beginChunkedTransfer(ctx, pathStr, size)
+	if err != nil {
+		return "", err
+	}
+
+	// Grow the chunks if the file would otherwise need too many
+	chunkSize := max(c.chunkSize, (size+maxChunks-1)/maxChunks)
+	parts := int((size + chunkSize - 1) / chunkSize)
+
+	uploaded := map[int]int64{}
+	for pass := 1; ; pass++ {
+		err = nil
+		for part := 1; part <= parts; part++ {
+			off := int64(part-1) * chunkSize
+			n := min(chunkSize, size-off)
+			if uploaded[part] == n {
+				continue
+			}
+			if err = t.putChunk(ctx, part, io.NewSectionReader(src, off, n)); err != nil {
+				break
+			}
+			uploaded[part] = n
+		}
+		if err == nil {
+			break
+		}
+
+		if pass >= maxChunkedUploadPasses || ctx.Err() != nil || !resumableUploadError(err) {
+			t.abort(context.WithoutCancel(ctx))
+			return "", chunkError(err, pathStr)
+		}
+
+		// Trust the server's view of what arrived
+		if uploaded, err = t.uploadedChunks(ctx); err != nil {
+			t.abort(context.WithoutCancel(ctx))
+			return "", err
+		}
+	}
+
+	etag, err := t.assemble(ctx, size, pre)
+	if err != nil {
+		t.abort(context.WithoutCancel(ctx))
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// resumableUploadError reports whether a failed chunk is worth sending again:
+// network failures and 5xx responses are, client errors are not
+func resumableUploadError(err error) bool {
+	if werr, ok := err.(*WebDAVError); ok {
+		return werr.StatusCode >= 500 && werr.StatusCode != http.StatusInsufficientStorage
+	}
+	return true
+}
+
+// chunkError converts a chunk failure into the error reported for pathStr
+func chunkError(err error, pathStr string) error {
+	if werr, ok := err.(*WebDAVError); ok {
+		return httpStatusToOSError(werr.StatusCode, pathStr)
+	}
+	return err
+}
+
+// chunkedStream streams sequential writes through the chunked upload
+// protocol, holding at most one chunk in memory. Data that fits in a single
+// chunk is sent as a plain PUT instead.
+type chunkedStream struct {
+	c       *webdavClient
+	ctx     context.Context
+	pathStr string
+	pre     precondition
+	buf     []byte
+	t       *chunkedTransfer
+	part    int
+	total   int64
+	err     error
+}
+
+func (s *chunkedStream) Write(b []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	written := 0
+	for len(b) > 0 {
+		room := int(s.c.chunkSize) - len(s.buf)
+		n := min(room, len(b))
+		s.buf = append(s.buf, b[:n]...)
+		b = b[n:]
+		written += n
+
+		if len(s.buf) == int(s.c.chunkSize) && len(b) > 0 {
+			// Only send a full chunk once more data follows, so that the
+			// last chunk is never empty
+			if err := s.sendChunk(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// sendChunk uploads the buffered chunk, starting the transfer if needed. A
+// chunk that fails with a server or network error is sent again before the
+// transfer is given up.
+func (s *chunkedStream) sendChunk() error {
+	if s.t == nil {
+		t, err := s.c.beginChunkedTransfer(s.ctx, s.pathStr, -1)
+		if err != nil {
+			s.err = err
+			return err
+		}
+		s.t = t
+	}
+
+	s.part++
+	if s.part > maxChunks {
+		s.err = &os.PathError{Op: "write", Path: s.pathStr, Err: errors.New("file too large for the configured chunk size")}
+		s.t.abort(context.WithoutCancel(s.ctx))
+		return s.err
+	}
+
+	// Earlier chunks were acknowledged, so resuming means sending this one
+	// again from the buffer
+	for pass := 1; ; pass++ {
+		err := s.t.putChunk(s.ctx, s.part, bytes.NewReader(s.buf))
+		if err == nil {
+			break
+		}
+		if pass >= maxChunkedUploadPasses || s.ctx.Err() != nil || !resumableUploadError(err) {
+			s.err = chunkError(err, s.pathStr)
+			s.t.abort(context.WithoutCancel(s.ctx))
+			return s.err
+		}
+	}
+
+	s.total += int64(len(s.buf))
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *chunkedStream) finish() (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+
+	// Small files go up in one request
+	if s.t == nil {
+		return s.c.putCond(s.ctx, s.pathStr, bytes.NewReader(s.buf), s.pre)
+	}
+
+	if err := s.sendChunk(); err != nil {
+		return "", err
+	}
+
+	etag, err := s.t.assemble(s.ctx, s.total, s.pre)
+	if err != nil {
+		s.t.abort(context.WithoutCancel(s.ctx))
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// abort discards the chunks sent so far
+func (s *chunkedStream) abort(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	if s.t != nil {
+		s.t.abort(context.WithoutCancel(s.ctx))
+	}
+}
@@ -0,0 +1,233 @@
+package webdavfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chunkRecorder wraps the stateful mock, recording chunked upload requests
+// and optionally failing the first attempt at one chunk
+type chunkRecorder struct {
+	mock *statefulMockServer
+
+	mu       sync.Mutex
+	methods  []string // Methods of requests under /uploads/
+	puts     []string // Paths of PUT requests outside /uploads/
+	totals   []string // OC-Total-Length headers of requests under /uploads/
+	failPart string   // Chunk whose first PUT fails with 500
+	failed   bool
+}
+
+func (c *chunkRecorder) handler(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	if strings.HasPrefix(r.URL.Path, "/uploads/") {
+		c.methods = append(c.methods, r.Method)
+		c.totals = append(c.totals, r.Header.Get("OC-Total-Length"))
+		if r.Method == "PUT" && c.failPart != "" && !c.failed && strings.HasSuffix(r.URL.Path, "/"+c.failPart) {
+			c.failed = true
+			c.mu.Unlock()
+			http.Error(w, "temporarily broken", http.StatusInternalServerError)
+			return
+		}
+	} else if r.Method == "PUT" {
+		c.puts = append(c.puts, r.URL.Path)
+	}
+	c.mu.Unlock()
+
+	c.mock.handler(w, r)
+}
+
+func (c *chunkRecorder) count(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, m := range c.methods {
+		if m == method {
+			n++
+		}
+	}
+	return n
+}
+
+func newChunkedTestFS(t *testing.T, rec *chunkRecorder) *FileSystem {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(rec.handler))
+	t.Cleanup(server.Close)
+
+	fs, err := New(&Config{
+		URL:              server.URL,
+		ChunkedUploadURL: server.URL + "/uploads/user/",
+		ChunkSize:        1000,
+		Retry:            &RetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	return fs
+}
+
+// assertNoUploadsLeft checks that the upload collection was cleaned up
+func (c *chunkRecorder) assertNoUploadsLeft(t *testing.T) {
+	t.Helper()
+	c.mock.mu.RLock()
+	defer c.mock.mu.RUnlock()
+	for p := range c.mock.files {
+		if strings.HasPrefix(p, "/uploads/") {
+			t.Errorf("chunk %s left behind", p)
+		}
+	}
+	for p := range c.mock.dirs {
+		if strings.HasPrefix(p, "/uploads/") {
+			t.Errorf("upload collection %s left behind", p)
+		}
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	rec := &chunkRecorder{mock: newStatefulMock()}
+	fs := newChunkedTestFS(t, rec)
+
+	data := bytes.Repeat([]byte("0123456789"), 250)
+	if err := fs.WriteFile("/big.bin", data, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	got, err := fs.ReadFile("/big.bin")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("content mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+
+	if n := rec.count("MKCOL"); n != 1 {
+		t.Errorf("expected 1 MKCOL, got %d", n)
+	}
+	if n := rec.count("PUT"); n != 3 {
+		t.Errorf("expected 3 chunk PUTs, got %d", n)
+	}
+	if n := rec.count("MOVE"); n != 1 {
+		t.Errorf("expected 1 MOVE, got %d", n)
+	}
+	rec.assertNoUploadsLeft(t)
+}
+
+func TestChunkedUpload_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefghij"), 350)
+
+	t.Run("stream", func(t *testing.T) {
+		rec := &chunkRecorder{mock: newStatefulMock(), failPart: "2"}
+		fs := newChunkedTestFS(t, rec)
+
+		if err := fs.WriteFile("/big.bin", data, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+
+		got, err := fs.ReadFile("/big.bin")
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("content mismatch: got %d bytes, want %d", len(got), len(data))
+		}
+		// Four chunks plus the failed attempt
+		if n := rec.count("PUT"); n != 5 {
+			t.Errorf("expected 5 chunk PUTs, got %d", n)
+		}
+		rec.assertNoUploadsLeft(t)
+	})
+
+	t.Run("write-back", func(t *testing.T) {
+		rec := &chunkRecorder{mock: newStatefulMock()}
+		fs := newChunkedTestFS(t, rec)
+
+		if err := fs.WriteFile("/big.bin", data, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		rec.mu.Lock()
+		rec.methods = nil
+		rec.failPart = "3"
+		rec.mu.Unlock()
+
+		f, err := fs.OpenFile("/big.bin", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("OpenFile() error = %v", err)
+		}
+		if _, err := f.WriteAt([]byte("XYZ"), 1500); err != nil {
+			t.Fatalf("WriteAt() error = %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		want := append([]byte(nil), data...)
+		copy(want[1500:], "XYZ")
+		got, err := fs.ReadFile("/big.bin")
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("content mismatch after resumed upload")
+		}
+		// The resume asked the server which chunks arrived and sent only
+		// the missing ones again
+		if n := rec.count("PROPFIND"); n != 1 {
+			t.Errorf("expected 1 PROPFIND of the upload collection, got %d", n)
+		}
+		if n := rec.count("PUT"); n != 5 {
+			t.Errorf("expected 5 chunk PUTs, got %d", n)
+		}
+		rec.assertNoUploadsLeft(t)
+	})
+}
+
+func TestChunkedUpload_SmallFile(t *testing.T) {
+	rec := &chunkRecorder{mock: newStatefulMock()}
+	fs := newChunkedTestFS(t, rec)
+
+	data := []byte("fits in a single chunk")
+	if err := fs.WriteFile("/small.txt", data, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	got, err := fs.ReadFile("/small.txt")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadFile() = %q, want %q", got, data)
+	}
+
+	if len(rec.methods) != 0 {
+		t.Errorf("expected no chunked upload requests, got %v", rec.methods)
+	}
+	// The create PUT followed by the single PUT of the content
+	if len(rec.puts) != 2 {
+		t.Errorf("expected 2 PUTs of the file, got %v", rec.puts)
+	}
+}
+
+func TestChunkedUpload_DeclaresTotalLength(t *testing.T) {
+	rec := &chunkRecorder{mock: newStatefulMock()}
+	fs := newChunkedTestFS(t, rec)
+
+	// The size of a buffered upload is known before the first chunk
+	data := bytes.Repeat([]byte("0123456789"), 250)
+	if _, err := fs.WriteFileIfNotExist("/big.bin", data); err != nil {
+		t.Fatalf("WriteFileIfNotExist() error = %v", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for i, method := range rec.methods {
+		if method != "DELETE" && rec.totals[i] != "2500" {
+			t.Errorf("%s OC-Total-Length = %q, want 2500", method, rec.totals[i])
+		}
+	}
+}
@@ -1,24 +1,40 @@
 package webdavfs
 
 import (
-	"bytes"
+	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // webdavClient handles HTTP communication with the WebDAV server
 type webdavClient struct {
-	httpClient  *http.Client
-	baseURL     *url.URL
-	username    string
-	password    string
-	bearerToken string
+	httpClient *http.Client
+	baseURL    *url.URL
+	auth       Authenticator
+	retry      *RetryPolicy
+	uploadsURL *url.URL // Chunked upload collection, if enabled
+	chunkSize  int64
+	locks      lockRegistry // Locks held through this client
+	extraProps []xml.Name   // Properties requested in addition to the defaults
+	cache      *metadataCache
+	blocks     *blockCache
+	contents   *contentCache
+	caps       capabilityCache // Discovered with OPTIONS on first use
+	partial    partialUpdates  // Dialect for writing back modified ranges
+	quotaCheck bool            // Check the quota before uploads of known size
+
+	walkConcurrency int         // Directories listed in parallel by a crawl
+	finiteDepth     atomic.Bool // Whether the server refused Depth: infinity
+
+	maxRedirects int             // Redirects followed per request; negative for none
+	slashes      trailingSlashes // Collections redirected to a trailing slash
 }
 
 // newWebDAVClient creates a new WebDAV client
@@ -33,49 +49,140 @@ func newWebDAVClient(config *Config) (*webdavClient, error) {
 		baseURL.Path += "/"
 	}
 
+	var uploadsURL *url.URL
+	if config.ChunkedUploadURL != "" {
+		uploadsURL, err = url.Parse(config.ChunkedUploadURL)
+		if err != nil || !uploadsURL.IsAbs() {
+			return nil, &ConfigError{Field: "ChunkedUploadURL", Reason: "must be an absolute URL"}
+		}
+	}
+
+	// The default client is built here rather than stored in config, so
+	// that the caller's Config can be passed to New again
+	base := config.HTTPClient
+	if base == nil {
+		base = defaultHTTPClient(config.Timeout)
+	}
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		// validate ensures base is the default client, not the caller's
+		base.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	}
+
+	// Redirects are followed by sendFollowingRedirects, which keeps the
+	// method and body. The caller's client is copied rather than modified.
+	httpClient := *base
+	httpClient.CheckRedirect = noFollow
+
+	contents, err := newContentCache(config.ContentCacheDir, config.ContentCacheMaxBytes, baseURL.String())
+	if err != nil {
+		return nil, err
+	}
+
 	return &webdavClient{
-		httpClient:  config.HTTPClient,
-		baseURL:     baseURL,
-		username:    config.Username,
-		password:    config.Password,
-		bearerToken: config.BearerToken,
+		httpClient: &httpClient,
+		baseURL:    baseURL,
+		auth:       config.authenticator(),
+		retry:      config.Retry,
+		uploadsURL: uploadsURL,
+		chunkSize:  config.ChunkSize,
+		extraProps: config.ExtraProperties,
+		cache:      newMetadataCache(config.MetadataCache),
+		blocks:     newBlockCache(config.BlockCache),
+		contents:   contents,
+		partial:    partialUpdates{dialect: config.PartialUpdate},
+		quotaCheck: config.QuotaCheck,
+
+		walkConcurrency: config.WalkConcurrency,
+		maxRedirects:    config.MaxRedirects,
 	}, nil
 }
 
+// defaultHTTPClient returns the client used when Config.HTTPClient is not
+// set. Its transport waits at most timeout for the server to start
+// responding.
+func defaultHTTPClient(timeout time.Duration) *http.Client {
+	// DefaultTransport may have been replaced by the application
+	var transport *http.Transport
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = t.Clone()
+	} else {
+		transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+	}
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{Transport: transport}
+}
+
+// invalidate discards everything cached about the given paths after they
+// were modified through this client
+func (c *webdavClient) invalidate(paths ...string) {
+	c.cache.invalidate(paths...)
+	for _, p := range paths {
+		c.slashes.forget(c.urlPath(p))
+	}
+	if c.contents != nil {
+		for _, p := range paths {
+			c.contents.invalidate(p)
+		}
+	}
+}
+
 // buildURL constructs the full URL for a path
 func (c *webdavClient) buildURL(pathStr string) (*url.URL, error) {
-	// Clean and normalize the path
-	pathStr = path.Clean("/" + strings.TrimPrefix(pathStr, "/"))
-
 	// Parse as URL to properly handle encoding
 	u, err := url.Parse(c.baseURL.String())
 	if err != nil {
 		return nil, err
 	}
 
-	// Join paths
-	u.Path = path.Join(u.Path, pathStr)
+	// Join the cleaned path, in the canonical form the server redirected
+	// to if it did
+	u.Path = c.slashes.canonical(c.urlPath(pathStr))
 
 	return u, nil
 }
 
-// doRequest performs an HTTP request with authentication
-func (c *webdavClient) doRequest(method, pathStr string, body io.Reader, headers map[string]string) (*http.Response, error) {
+// doRequest performs an HTTP request with authentication. The request is
+// bound to ctx, so cancelling ctx aborts it along with any response body
+// still being read.
+func (c *webdavClient) doRequest(ctx context.Context, method, pathStr string, body io.Reader, headers map[string]string) (*http.Response, error) {
 	reqURL, err := c.buildURL(pathStr)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(method, reqURL.String(), body)
+	return c.doRequestURL(ctx, method, reqURL, pathStr, body, headers)
+}
+
+// doRequestURL performs an HTTP request against an absolute URL, which may lie
+// outside the base URL. pathStr names the resource in returned errors.
+func (c *webdavClient) doRequestURL(ctx context.Context, method string, reqURL *url.URL, pathStr string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add authentication
-	if c.bearerToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
-	} else if c.username != "" || c.password != "" {
-		req.SetBasicAuth(c.username, c.password)
+	// Make seekable bodies, such as spooled files, replayable for retries.
+	// Every replay reads its own section, so an attempt still being torn down
+	// cannot disturb the next one.
+	if ra, ok := body.(readSeekerAt); ok && req.GetBody == nil {
+		if start, end, err := remainingSection(ra); err == nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(ra, start, end-start)), nil
+			}
+			if req.ContentLength == 0 {
+				req.ContentLength = end - start
+			}
+		}
 	}
 
 	// Add custom headers
@@ -83,33 +190,130 @@ func (c *webdavClient) doRequest(method, pathStr string, body io.Reader, headers
 		req.Header.Set(k, v)
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.sendFollowingRedirects(req)
 	if err != nil {
-		return nil, &os.PathError{Op: method, Path: pathStr, Err: err}
+		return nil, &os.PathError{Op: method, Path: pathStr, Err: contextError(ctx, err)}
 	}
 
 	return resp, nil
 }
 
+// sendAuthorized sends req with credentials from the authenticator. If the
+// server answers with a challenge the authenticator can meet, the request
+// is sent once more, provided its body can be replayed.
+func (c *webdavClient) sendAuthorized(req *http.Request) (*http.Response, error) {
+	if c.auth == nil {
+		return c.send(req)
+	}
+
+	retry := req.Clone(req.Context())
+	if err := c.auth.Authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Let the authenticator learn from the challenge even if this request
+	// cannot be replayed, so that later requests are authorized up front
+	again, err := c.auth.Challenge(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if !again || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	if err := c.auth.Authorize(retry); err != nil {
+		return resp, nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return c.send(retry)
+}
+
+// readSeekerAt is a request body that can be replayed from any position
+type readSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// remainingSection returns the offsets of the unread part of r, leaving its
+// position unchanged
+func remainingSection(r io.Seeker) (start, end int64, err error) {
+	if start, err = r.Seek(0, io.SeekCurrent); err != nil {
+		return 0, 0, err
+	}
+	if end, err = r.Seek(0, io.SeekEnd); err != nil {
+		return 0, 0, err
+	}
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// contextError reports ctx's error in place of err once ctx is done, so that
+// cancellation and deadlines surface as context.Canceled or
+// context.DeadlineExceeded rather than transport-specific errors.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
 // propfind performs a PROPFIND request
-func (c *webdavClient) propfind(pathStr string, depth int) (*multistatus, error) {
+func (c *webdavClient) propfind(ctx context.Context, pathStr string, depth int) (*multistatus, error) {
+	resp, err := c.propfindRequest(ctx, pathStr, depth, buildPropfindBody(c.extraProps...))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ms, err := parseMultistatus(resp.Body)
+	if err != nil {
+		return nil, &os.PathError{Op: "propfind", Path: pathStr, Err: contextError(ctx, err)}
+	}
+
+	return ms, nil
+}
+
+// depthInfinity requests the whole subtree from propfindRequest
+const depthInfinity = -1
+
+// propfindRequest sends a PROPFIND with the given body and returns the
+// response if the server answered 207 Multi-Status
+func (c *webdavClient) propfindRequest(ctx context.Context, pathStr string, depth int, body string) (*http.Response, error) {
 	headers := map[string]string{
 		"Content-Type": "application/xml",
 		"Depth":        fmt.Sprintf("%d", depth),
 	}
+	if depth == depthInfinity {
+		headers["Depth"] = "infinity"
+	}
 
-	body := buildPropfindBody()
-	resp, err := c.doRequest("PROPFIND", pathStr, strings.NewReader(body), headers)
+	resp, err := c.doRequest(ctx, "PROPFIND", pathStr, strings.NewReader(body), headers)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode == 404 {
+		resp.Body.Close()
 		return nil, &os.PathError{Op: "stat", Path: pathStr, Err: os.ErrNotExist}
 	}
 
 	if resp.StatusCode != 207 { // 207 Multi-Status
+		defer resp.Body.Close()
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return nil, &WebDAVError{
 			StatusCode: resp.StatusCode,
@@ -119,17 +323,27 @@ func (c *webdavClient) propfind(pathStr string, depth int) (*multistatus, error)
 		}
 	}
 
-	ms, err := parseMultistatus(resp.Body)
-	if err != nil {
-		return nil, &os.PathError{Op: "propfind", Path: pathStr, Err: err}
+	return resp, nil
+}
+
+// stat retrieves file information, from the metadata cache if possible
+func (c *webdavClient) stat(ctx context.Context, pathStr string) (os.FileInfo, error) {
+	if info, err, ok := c.cache.stat(pathStr); ok {
+		return info, err
 	}
 
-	return ms, nil
+	info, err := c.statUncached(ctx, pathStr)
+	if err == nil {
+		c.cache.putStat(pathStr, info)
+	} else if os.IsNotExist(err) {
+		c.cache.putStat(pathStr, nil)
+	}
+	return info, err
 }
 
-// stat retrieves file information
-func (c *webdavClient) stat(pathStr string) (os.FileInfo, error) {
-	ms, err := c.propfind(pathStr, 0)
+// statUncached retrieves file information from the server
+func (c *webdavClient) statUncached(ctx context.Context, pathStr string) (os.FileInfo, error) {
+	ms, err := c.propfind(ctx, pathStr, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -142,125 +356,179 @@ func (c *webdavClient) stat(pathStr string) (os.FileInfo, error) {
 }
 
 // readDir lists directory contents
-func (c *webdavClient) readDir(pathStr string) ([]os.FileInfo, error) {
+func (c *webdavClient) readDir(ctx context.Context, pathStr string) ([]os.FileInfo, error) {
 	// Ensure path ends with / for directory listing
 	if !strings.HasSuffix(pathStr, "/") {
 		pathStr += "/"
 	}
 
-	ms, err := c.propfind(pathStr, 1)
+	if infos, ok := c.cache.listing(pathStr); ok {
+		return infos, nil
+	}
+
+	l, err := c.listDir(ctx, pathStr)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(ms.Responses) == 0 {
-		return []os.FileInfo{}, nil
+	infos, err := l.next(0)
+	if err != io.EOF {
+		return nil, err
 	}
 
-	// First response is the directory itself, skip it
-	var infos []os.FileInfo
-	for i := 1; i < len(ms.Responses); i++ {
-		info, err := parseFileInfo(ms.Responses[i], pathStr)
-		if err != nil {
-			continue // Skip entries we can't parse
-		}
-		infos = append(infos, info)
-	}
+	c.cache.putListing(pathStr, l.self, infos)
 
 	return infos, nil
 }
 
-// get downloads file content
-func (c *webdavClient) get(pathStr string, offset int64) (io.ReadCloser, error) {
-	headers := make(map[string]string)
-	if offset > 0 {
-		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+// get downloads file content from offset to the end, through the content
+// cache if enabled
+func (c *webdavClient) get(ctx context.Context, pathStr string, offset int64) (io.ReadCloser, error) {
+	if c.contents != nil {
+		return c.contents.get(ctx, c, pathStr, offset)
 	}
+	body, _, err := c.getRange(ctx, pathStr, offset, -1)
+	return body, err
+}
 
-	resp, err := c.doRequest("GET", pathStr, nil, headers)
-	if err != nil {
-		return nil, err
+// getRange downloads length bytes of file content starting at offset, or
+// everything from offset if length is negative, and returns the ETag of the
+// version served. The body may be shorter than length at the end of the file.
+func (c *webdavClient) getRange(ctx context.Context, pathStr string, offset, length int64) (io.ReadCloser, string, error) {
+	if length == 0 {
+		return http.NoBody, "", nil
 	}
 
-	if resp.StatusCode != 200 && resp.StatusCode != 206 { // 200 OK or 206 Partial Content
-		resp.Body.Close()
-		return nil, httpStatusToOSError(resp.StatusCode, pathStr)
+	resp, err := c.doRequest(ctx, "GET", pathStr, nil, rangeHeaders(offset, length))
+	if err != nil {
+		return nil, "", err
 	}
-
-	return resp.Body, nil
+	return rangeBody(ctx, resp, pathStr, offset, length)
 }
 
-// put uploads file content
-func (c *webdavClient) put(pathStr string, data io.Reader) error {
-	headers := map[string]string{
-		"Content-Type": "application/octet-stream",
+// rangeHeaders returns the headers of a GET for length bytes from offset,
+// or everything from offset if length is negative
+func rangeHeaders(offset, length int64) map[string]string {
+	headers := make(map[string]string)
+	switch {
+	case length > 0:
+		headers["Range"] = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	case offset > 0:
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
 	}
+	return headers
+}
 
-	resp, err := c.doRequest("PUT", pathStr, data, headers)
-	if err != nil {
-		return err
+// rangeBody returns the requested part of the body of resp, a response to
+// a GET with rangeHeaders, along with the ETag of the version served
+func rangeBody(ctx context.Context, resp *http.Response, pathStr string, offset, length int64) (io.ReadCloser, string, error) {
+	etag := normalizeETag(resp.Header.Get("ETag"))
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, etag, nil
+	case http.StatusOK:
+		// The server ignored the Range header and sent the whole file
+		if offset > 0 {
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				resp.Body.Close()
+				if err == io.EOF {
+					return http.NoBody, etag, nil
+				}
+				return nil, "", &os.PathError{Op: "read", Path: pathStr, Err: contextError(ctx, err)}
+			}
+		}
+		if length > 0 {
+			return limitedReadCloser{io.LimitReader(resp.Body, length), resp.Body}, etag, nil
+		}
+		return resp.Body, etag, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The range starts at or beyond the end of the file
+		resp.Body.Close()
+		return http.NoBody, etag, nil
+	default:
+		resp.Body.Close()
+		return nil, "", httpStatusToOSError(resp.StatusCode, pathStr)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != 201 && resp.StatusCode != 204 { // 201 Created or 204 No Content
-		return httpStatusToOSError(resp.StatusCode, pathStr)
-	}
+// limitedReadCloser reads a prefix of a response body and closes the body
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
 
-	return nil
+// put uploads file content
+func (c *webdavClient) put(ctx context.Context, pathStr string, data io.Reader) error {
+	_, err := c.putCond(ctx, pathStr, data, precondition{})
+	return err
 }
 
-// putRange uploads partial file content
-func (c *webdavClient) putRange(pathStr string, data []byte, offset int64) error {
+// putCond uploads file content if the precondition holds and returns the
+// new ETag, if the server reports one
+func (c *webdavClient) putCond(ctx context.Context, pathStr string, data io.Reader, pre precondition) (string, error) {
+	defer c.invalidate(pathStr)
+
 	headers := map[string]string{
-		"Content-Type":  "application/octet-stream",
-		"Content-Range": fmt.Sprintf("bytes %d-%d/*", offset, offset+int64(len(data))-1),
+		"Content-Type": "application/octet-stream",
 	}
 
-	resp, err := c.doRequest("PUT", pathStr, bytes.NewReader(data), headers)
+	pre.setHeaders(headers)
+	headers = c.setIfHeader(headers, pathStr)
+
+	resp, err := c.doRequest(ctx, "PUT", pathStr, data, headers)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 201 && resp.StatusCode != 204 {
-		return httpStatusToOSError(resp.StatusCode, pathStr)
+	if resp.StatusCode == http.StatusPreconditionFailed && pre.active() {
+		return "", pre.failed(pathStr)
+	}
+	if resp.StatusCode != 201 && resp.StatusCode != 204 { // 201 Created or 204 No Content
+		return "", c.statusError(ctx, resp.StatusCode, pathStr)
 	}
 
-	return nil
+	return normalizeETag(resp.Header.Get("ETag")), nil
 }
 
 // mkcol creates a directory
-func (c *webdavClient) mkcol(pathStr string) error {
-	resp, err := c.doRequest("MKCOL", pathStr, nil, nil)
+func (c *webdavClient) mkcol(ctx context.Context, pathStr string) error {
+	defer c.invalidate(pathStr)
+
+	resp, err := c.doRequest(ctx, "MKCOL", pathStr, nil, c.setIfHeader(nil, pathStr))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 { // 201 Created
-		return httpStatusToOSError(resp.StatusCode, pathStr)
+		return c.statusError(ctx, resp.StatusCode, pathStr)
 	}
 
 	return nil
 }
 
 // delete removes a file or directory
-func (c *webdavClient) delete(pathStr string) error {
-	resp, err := c.doRequest("DELETE", pathStr, nil, nil)
+func (c *webdavClient) delete(ctx context.Context, pathStr string) error {
+	defer c.invalidate(pathStr)
+
+	resp, err := c.doRequest(ctx, "DELETE", pathStr, nil, c.setIfHeader(nil, pathStr))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 204 && resp.StatusCode != 200 { // 204 No Content or 200 OK
-		return httpStatusToOSError(resp.StatusCode, pathStr)
+		return c.statusError(ctx, resp.StatusCode, pathStr)
 	}
 
 	return nil
 }
 
 // move renames/moves a file or directory
-func (c *webdavClient) move(oldPath, newPath string) error {
+func (c *webdavClient) move(ctx context.Context, oldPath, newPath string, overwrite bool) error {
+	defer c.invalidate(oldPath, newPath)
+
 	destURL, err := c.buildURL(newPath)
 	if err != nil {
 		return err
@@ -268,38 +536,50 @@ func (c *webdavClient) move(oldPath, newPath string) error {
 
 	headers := map[string]string{
 		"Destination": destURL.String(),
-		"Overwrite":   "F", // Don't overwrite existing files
+		"Overwrite":   "F",
+	}
+	if overwrite {
+		headers["Overwrite"] = "T"
 	}
+	headers = c.setIfHeader(headers, oldPath, newPath)
 
-	resp, err := c.doRequest("MOVE", oldPath, nil, headers)
+	resp, err := c.doRequest(ctx, "MOVE", oldPath, nil, headers)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 && resp.StatusCode != 204 { // 201 Created or 204 No Content
-		return httpStatusToOSError(resp.StatusCode, oldPath)
+		return c.statusError(ctx, resp.StatusCode, oldPath)
 	}
 
 	return nil
 }
 
 // proppatch modifies properties
-func (c *webdavClient) proppatch(pathStr string, modTime time.Time) error {
+func (c *webdavClient) proppatch(ctx context.Context, pathStr string, modTime time.Time) error {
+	defer c.invalidate(pathStr)
+
 	headers := map[string]string{
 		"Content-Type": "application/xml",
 	}
 
+	headers = c.setIfHeader(headers, pathStr)
+
 	body := buildProppatchBody(modTime)
-	resp, err := c.doRequest("PROPPATCH", pathStr, strings.NewReader(body), headers)
+	resp, err := c.doRequest(ctx, "PROPPATCH", pathStr, strings.NewReader(body), headers)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 207 { // 207 Multi-Status
-		// Some servers don't support PROPPATCH, treat as non-fatal
-		return nil
+		// Some servers don't support PROPPATCH, so modification times are
+		// left as they are. Other failures are reported.
+		if c.unsupported(ctx, resp.StatusCode, "PROPPATCH") {
+			return nil
+		}
+		return c.statusError(ctx, resp.StatusCode, pathStr)
 	}
 
 	return nil
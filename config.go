@@ -1,6 +1,7 @@
 package webdavfs
 
 import (
+	"encoding/xml"
 	"net/http"
 	"time"
 )
@@ -19,13 +20,111 @@ type Config struct {
 	// BearerToken for Bearer token authentication (optional, mutually exclusive with Username/Password)
 	BearerToken string
 
+	// TokenSource supplies access tokens for Bearer authentication
+	// (optional, mutually exclusive with Username/Password, BearerToken and
+	// Auth). Tokens are reused until shortly before they expire; a token the
+	// server rejects is replaced and the request retried once.
+	TokenSource TokenSource
+
+	// Auth authenticates requests (optional, mutually exclusive with
+	// Username/Password and BearerToken). Use it for schemes such as
+	// DigestAuthenticator, or to supply a custom Authenticator.
+	Auth Authenticator
+
 	// HTTPClient allows customization of the HTTP client (optional)
 	// If nil, a default client with reasonable timeouts will be used
 	HTTPClient *http.Client
 
-	// Timeout for HTTP requests (default: 30 seconds)
+	// TLS configures certificate authorities, client certificates and public
+	// key pinning for the default HTTP client (optional, mutually exclusive
+	// with HTTPClient)
+	TLS *TLSConfig
+
+	// Timeout bounds how long the default HTTP client waits for the server to
+	// start responding to a request (default: 30 seconds). It does not limit
+	// how long a response body takes to stream, so long downloads are not cut
+	// off; use FileSystem.WithContext for per-operation deadlines.
 	Timeout time.Duration
 
+	// MaxRedirects bounds the number of redirects followed for a single
+	// request (default: 10). Redirects (301, 302, 307 and 308) are followed
+	// with the original method, body and headers; credentials are only sent
+	// to the host of the original request. A negative value disables
+	// following redirects. The CheckRedirect function of a custom
+	// HTTPClient is not used.
+	MaxRedirects int
+
+	// VerifyOnConnect makes New send OPTIONS to the server and fail unless
+	// it advertises WebDAV support (a DAV header with class 1). The result
+	// is kept and returned by FileSystem.Capabilities.
+	VerifyOnConnect bool
+
+	// Retry configures retries of requests that fail with transient errors
+	// (optional). If nil, every request is attempted exactly once.
+	Retry *RetryPolicy
+
+	// ChunkedUploadURL enables the Nextcloud/ownCloud chunked upload protocol
+	// for large uploads (optional). It is the user's upload collection, e.g.
+	// "https://cloud.example.com/remote.php/dav/uploads/user/". Uploads larger
+	// than ChunkSize are then sent as a series of chunks and assembled on the
+	// server, and failed chunks are resumed rather than starting over.
+	ChunkedUploadURL string
+
+	// ChunkSize is the size of each chunk of a chunked upload
+	// (default: 10 MiB). Nextcloud requires at least 5 MiB per chunk.
+	ChunkSize int64
+
+	// ExtraProperties lists properties to request with every PROPFIND in
+	// addition to the standard ones (optional). Their values are reported in
+	// ResourceInfo.Properties, returned by the Sys method of FileInfo.
+	ExtraProperties []xml.Name
+
+	// MetadataCache enables caching of Stat results and directory listings
+	// (optional). If nil, every Stat and Readdir queries the server.
+	MetadataCache *MetadataCacheConfig
+
+	// BlockCache enables caching of file content read with File.ReadAt
+	// (optional). If nil, every ReadAt requests exactly the bytes it reads.
+	BlockCache *BlockCacheConfig
+
+	// ContentCacheDir enables a persistent cache of downloaded file content
+	// in this local directory (optional). Cached content is revalidated with
+	// the server on every download (If-None-Match) and served from disk if
+	// unchanged. The directory may be shared by several processes.
+	ContentCacheDir string
+
+	// ContentCacheMaxBytes bounds the total size of the content cache; the
+	// least recently used files are evicted first (default: 1 GiB)
+	ContentCacheMaxBytes int64
+
+	// PartialUpdate selects how files modified with WriteAt, Write or
+	// Truncate are written back: by sending only the modified ranges in a
+	// server-specific dialect, or by uploading the whole file. The default,
+	// PartialUpdateAuto, detects the dialect from the server's capabilities.
+	PartialUpdate PartialUpdateDialect
+
+	// QuotaCheck makes uploads whose size is known in advance check the
+	// quota of the target directory first (RFC 4331), failing with
+	// ErrInsufficientStorage instead of transferring data the server has no
+	// room for. This applies to WriteFile, the write-back of modified files
+	// and streaming copies (File.ReadFrom) from sources whose length is
+	// known, such as local files.
+	QuotaCheck bool
+
+	// WalkConcurrency is the number of directories FileSystem.Walk and
+	// WalkDir list in parallel when the server refuses to return the whole
+	// tree at once (default: 8)
+	WalkConcurrency int
+
+	// SpoolThreshold is the size above which the local working copy of a file
+	// being modified is kept in a temporary file instead of in memory
+	// (default: 8 MiB)
+	SpoolThreshold int64
+
+	// SpoolDir is the local directory for working copies that exceed
+	// SpoolThreshold (optional). If empty, os.TempDir is used.
+	SpoolDir string
+
 	// TempDir specifies the temporary directory path on the WebDAV server (optional)
 	// If empty, defaults to "/tmp"
 	TempDir string
@@ -37,15 +136,41 @@ func (c *Config) setDefaults() {
 		c.Timeout = 30 * time.Second
 	}
 
-	if c.HTTPClient == nil {
-		c.HTTPClient = &http.Client{
-			Timeout: c.Timeout,
-		}
+	if c.MaxRedirects == 0 {
+		c.MaxRedirects = 10
 	}
 
 	if c.TempDir == "" {
 		c.TempDir = "/tmp"
 	}
+
+	if c.ChunkedUploadURL != "" && c.ChunkSize == 0 {
+		c.ChunkSize = 10 << 20
+	}
+
+	if c.ContentCacheDir != "" && c.ContentCacheMaxBytes == 0 {
+		c.ContentCacheMaxBytes = 1 << 30
+	}
+
+	if c.SpoolThreshold == 0 {
+		c.SpoolThreshold = 8 << 20
+	}
+
+	if c.WalkConcurrency == 0 {
+		c.WalkConcurrency = 8
+	}
+
+	if c.Retry != nil {
+		c.Retry.setDefaults()
+	}
+
+	if c.MetadataCache != nil {
+		c.MetadataCache.setDefaults()
+	}
+
+	if c.BlockCache != nil {
+		c.BlockCache.setDefaults()
+	}
 }
 
 // validate checks if the configuration is valid
@@ -61,6 +186,80 @@ func (c *Config) validate() error {
 			Reason: "BearerToken and Username/Password are mutually exclusive",
 		}
 	}
+	if c.TokenSource != nil && (c.BearerToken != "" || c.Username != "" || c.Password != "") {
+		return &ConfigError{
+			Field:  "Authentication",
+			Reason: "TokenSource is mutually exclusive with Username/Password and BearerToken",
+		}
+	}
+	if c.Auth != nil && (c.BearerToken != "" || c.Username != "" || c.Password != "" || c.TokenSource != nil) {
+		return &ConfigError{
+			Field:  "Authentication",
+			Reason: "Auth is mutually exclusive with Username/Password, BearerToken and TokenSource",
+		}
+	}
+
+	if c.TLS != nil && c.HTTPClient != nil {
+		return &ConfigError{
+			Field:  "TLS",
+			Reason: "TLS and HTTPClient are mutually exclusive; configure the TLS settings of the custom client's transport instead",
+		}
+	}
+
+	if c.ChunkSize < 0 {
+		return &ConfigError{Field: "ChunkSize", Reason: "must not be negative"}
+	}
+
+	if c.ContentCacheMaxBytes < 0 {
+		return &ConfigError{Field: "ContentCacheMaxBytes", Reason: "must not be negative"}
+	}
+
+	if c.PartialUpdate < PartialUpdateAuto || c.PartialUpdate > PartialUpdateApache {
+		return &ConfigError{Field: "PartialUpdate", Reason: "unknown dialect " + c.PartialUpdate.String()}
+	}
+
+	if c.SpoolThreshold < 0 {
+		return &ConfigError{Field: "SpoolThreshold", Reason: "must not be negative"}
+	}
+
+	if c.WalkConcurrency < 0 {
+		return &ConfigError{Field: "WalkConcurrency", Reason: "must not be negative"}
+	}
+
+	if c.Retry != nil {
+		if err := c.Retry.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.MetadataCache != nil {
+		if err := c.MetadataCache.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.BlockCache != nil {
+		if err := c.BlockCache.validate(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// authenticator returns the Authenticator for the configured credentials,
+// or nil if requests are sent without credentials
+func (c *Config) authenticator() Authenticator {
+	switch {
+	case c.Auth != nil:
+		return c.Auth
+	case c.TokenSource != nil:
+		return &tokenAuthenticator{src: c.TokenSource, now: time.Now}
+	case c.BearerToken != "":
+		return &BearerAuthenticator{Token: c.BearerToken}
+	case c.Username != "" || c.Password != "":
+		return &BasicAuthenticator{Username: c.Username, Password: c.Password}
+	default:
+		return nil
+	}
+}
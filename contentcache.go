@@ -0,0 +1,234 @@
+package webdavfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// contentCacheMagic starts every content cache entry, followed by the ETag
+// of the cached version on the same line
+const contentCacheMagic = "webdavfs-cache-1 "
+
+// staleTempAge is the age after which an unfinished temporary file in the
+// cache directory is considered abandoned by a crashed process
+const staleTempAge = time.Hour
+
+// contentCache stores downloaded file content on local disk, keyed by path
+// and ETag, and revalidates it with If-None-Match on every download.
+//
+// Each entry is a single file holding the ETag and the content. Entries are
+// written to a temporary file and renamed into place, so processes sharing
+// the directory only ever see complete entries. The modification time of an
+// entry records its last use, for LRU eviction.
+type contentCache struct {
+	dir      string
+	maxBytes int64
+	baseURL  string
+}
+
+// newContentCache returns a cache in dir, creating the directory if needed,
+// or nil if dir is empty
+func newContentCache(dir string, maxBytes int64, baseURL string) (*contentCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, &ConfigError{Field: "ContentCacheDir", Reason: err.Error()}
+	}
+	return &contentCache{dir: dir, maxBytes: maxBytes, baseURL: baseURL}, nil
+}
+
+// entryPath returns the file holding the cached content of pathStr
+func (cc *contentCache) entryPath(pathStr string) string {
+	sum := sha256.Sum256([]byte(cc.baseURL + "\x00" + pathStr))
+	return filepath.Join(cc.dir, hex.EncodeToString(sum[:])+".cache")
+}
+
+// open returns the cached entry of pathStr positioned at the start of the
+// content, along with its ETag
+func (cc *contentCache) open(pathStr string) (*os.File, string, bool) {
+	f, err := os.Open(cc.entryPath(pathStr))
+	if err != nil {
+		return nil, "", false
+	}
+
+	header, err := bufio.NewReader(f).ReadString('\n')
+	etag := strings.TrimSuffix(strings.TrimPrefix(header, contentCacheMagic), "\n")
+	if err != nil || !strings.HasPrefix(header, contentCacheMagic) || etag == "" {
+		f.Close()
+		return nil, "", false
+	}
+	if _, err := f.Seek(int64(len(header)), io.SeekStart); err != nil {
+		f.Close()
+		return nil, "", false
+	}
+	return f, etag, true
+}
+
+// get downloads the content of pathStr from offset, serving it from the
+// cache if the server confirms the cached version is current
+func (cc *contentCache) get(ctx context.Context, c *webdavClient, pathStr string, offset int64) (io.ReadCloser, error) {
+	headers := rangeHeaders(offset, -1)
+	entry, etag, cached := cc.open(pathStr)
+	if cached {
+		headers["If-None-Match"] = etag
+	}
+
+	resp, err := c.doRequest(ctx, "GET", pathStr, nil, headers)
+	if err != nil {
+		if cached {
+			entry.Close()
+		}
+		return nil, err
+	}
+
+	if cached {
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			cc.touch(entry.Name())
+			if _, err := entry.Seek(offset, io.SeekCurrent); err != nil {
+				entry.Close()
+				return nil, &os.PathError{Op: "read", Path: pathStr, Err: err}
+			}
+			return entry, nil
+		}
+		entry.Close()
+	}
+
+	body, etag, err := rangeBody(ctx, resp, pathStr, offset, -1)
+	if err != nil || resp.StatusCode != http.StatusOK || offset != 0 || etag == "" || strings.Contains(etag, "\n") {
+		return body, err
+	}
+	return cc.fill(pathStr, etag, body), nil
+}
+
+// fill returns a reader of body that stores the content in the cache once
+// it has been read completely
+func (cc *contentCache) fill(pathStr, etag string, body io.ReadCloser) io.ReadCloser {
+	tmp, err := os.CreateTemp(cc.dir, "tmp-*")
+	if err != nil {
+		return body
+	}
+	if _, err := tmp.WriteString(contentCacheMagic + etag + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return body
+	}
+	return &cacheFill{body: body, tmp: tmp, cache: cc, entry: cc.entryPath(pathStr)}
+}
+
+// cacheFill copies a download into a temporary file and moves it into the
+// cache when the download completes
+type cacheFill struct {
+	body  io.ReadCloser
+	tmp   *os.File // nil once committed or abandoned
+	cache *contentCache
+	entry string
+}
+
+func (f *cacheFill) Read(b []byte) (int, error) {
+	n, err := f.body.Read(b)
+	if f.tmp != nil && n > 0 {
+		if _, werr := f.tmp.Write(b[:n]); werr != nil {
+			f.abandon()
+		}
+	}
+	if f.tmp != nil && err == io.EOF {
+		f.commit()
+	}
+	return n, err
+}
+
+func (f *cacheFill) Close() error {
+	if f.tmp != nil {
+		// The download was not read to the end
+		f.abandon()
+	}
+	return f.body.Close()
+}
+
+// commit moves the complete download into place
+func (f *cacheFill) commit() {
+	tmp := f.tmp
+	f.tmp = nil
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), f.entry); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	f.cache.evict()
+}
+
+// abandon discards an incomplete download
+func (f *cacheFill) abandon() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+	f.tmp = nil
+}
+
+// touch marks an entry as recently used
+func (cc *contentCache) touch(name string) {
+	now := time.Now()
+	os.Chtimes(name, now, now)
+}
+
+// invalidate removes the cached content of pathStr. An entry that cannot
+// be removed is harmless, since it is revalidated before use.
+func (cc *contentCache) invalidate(pathStr string) {
+	os.Remove(cc.entryPath(pathStr))
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxBytes, along with temporary files abandoned by crashed processes
+func (cc *contentCache) evict() {
+	dirEntries, err := os.ReadDir(cc.dir)
+	if err != nil {
+		return
+	}
+
+	type entry struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+	for _, de := range dirEntries {
+		info, err := de.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		name := filepath.Join(cc.dir, de.Name())
+		switch {
+		case strings.HasSuffix(de.Name(), ".cache"):
+			entries = append(entries, entry{name, info.Size(), info.ModTime()})
+			total += info.Size()
+		case strings.HasPrefix(de.Name(), "tmp-") && time.Since(info.ModTime()) > staleTempAge:
+			os.Remove(name)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if total <= cc.maxBytes {
+			break
+		}
+		// Another process may have removed it already
+		os.Remove(e.name)
+		total -= e.size
+	}
+}
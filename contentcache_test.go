@@ -0,0 +1,171 @@
+package webdavfs
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// newGetStatusServer returns a WebDAV server that records the status of
+// every GET response
+func newGetStatusServer(t *testing.T) (*httptest.Server, func() []int) {
+	t.Helper()
+	var mu sync.Mutex
+	var statuses []int
+	handler := &webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		if r.Method == "GET" {
+			mu.Lock()
+			statuses = append(statuses, rec.status)
+			mu.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		got := statuses
+		statuses = nil
+		return got
+	}
+}
+
+func TestContentCache(t *testing.T) {
+	server, statuses := newGetStatusServer(t)
+	dir := t.TempDir()
+
+	fs, err := New(&Config{URL: server.URL, ContentCacheDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	content := bytes.Repeat([]byte("large and rarely changing "), 100)
+	if err := other.WriteFile("/big.txt", content, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	read := func(fs *FileSystem, want []byte) {
+		t.Helper()
+		got, err := fs.ReadFile("/big.txt")
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("ReadFile() = %d bytes, %v; want %d bytes", len(got), err, len(want))
+		}
+	}
+
+	read(fs, content)
+	read(fs, content)
+	if got := statuses(); len(got) != 2 || got[0] != http.StatusOK || got[1] != http.StatusNotModified {
+		t.Errorf("GET statuses = %v, want [200 304]", got)
+	}
+
+	// A partial read is served from the cache too
+	f, err := fs.Open("/big.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := f.Seek(26, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(f, b); err != nil || string(b) != "large" {
+		t.Errorf("Read() after Seek = %q, %v; want %q", b, err, "large")
+	}
+	f.Close()
+	if got := statuses(); len(got) != 1 || got[0] != http.StatusNotModified {
+		t.Errorf("GET statuses = %v, want [304]", got)
+	}
+
+	// The cache survives a restart
+	restarted, err := New(&Config{URL: server.URL, ContentCacheDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	read(restarted, content)
+	if got := statuses(); len(got) != 1 || got[0] != http.StatusNotModified {
+		t.Errorf("GET statuses after restart = %v, want [304]", got)
+	}
+
+	// A change by another client is detected by the ETag
+	changed := []byte("changed elsewhere")
+	if err := other.WriteFile("/big.txt", changed, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	read(fs, changed)
+	if got := statuses(); len(got) != 1 || got[0] != http.StatusOK {
+		t.Errorf("GET statuses after change = %v, want [200]", got)
+	}
+
+	// Our own writes remove the entry
+	if err := fs.WriteFile("/big.txt", []byte("ours"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(dir, "*.cache")); len(entries) != 0 {
+		t.Errorf("cache entries after own write = %v, want none", entries)
+	}
+	read(fs, []byte("ours"))
+}
+
+func TestContentCache_Eviction(t *testing.T) {
+	server, _ := newGetStatusServer(t)
+	dir := t.TempDir()
+
+	fs, err := New(&Config{URL: server.URL, ContentCacheDir: dir, ContentCacheMaxBytes: 250})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	for _, name := range []string{"/a", "/b", "/c"} {
+		if err := fs.WriteFile(name, bytes.Repeat([]byte("x"), 100), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if _, err := fs.ReadFile(name); err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+	}
+
+	var total int64
+	entries, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	for _, e := range entries {
+		info, err := os.Stat(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+	}
+	if total > 250 || len(entries) == 0 {
+		t.Errorf("cache holds %d bytes in %d entries, want at most 250 bytes", total, len(entries))
+	}
+	if _, _, ok := fs.client.contents.open("/c"); !ok {
+		t.Error("the most recently used entry was evicted")
+	}
+	if _, _, ok := fs.client.contents.open("/a"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+}
@@ -0,0 +1,166 @@
+package webdavfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CopyOptions configures FileSystem.Copy
+type CopyOptions struct {
+	// Shallow copies only the resource itself (Depth: 0). For a directory
+	// this creates an empty directory at the destination. By default a
+	// directory is copied with all of its contents (Depth: infinity).
+	Shallow bool
+
+	// Overwrite replaces an existing destination. Otherwise Copy fails with
+	// an error matching os.ErrExist when the destination exists.
+	Overwrite bool
+}
+
+// Copier is implemented by filesystems that can copy files and directories
+// without transferring their content through the client
+type Copier interface {
+	Copy(src, dst string, opts CopyOptions) error
+}
+
+// Copy copies src to dst on the server using WebDAV COPY, without
+// downloading the content. If the copy fails for only some resources of a
+// directory tree, the returned *MultiStatusError lists them.
+func (fs *FileSystem) Copy(src, dst string, opts CopyOptions) error {
+	src = fs.cleanPath(src)
+	dst = fs.cleanPath(dst)
+	return fs.client.copy(fs.context(), src, dst, opts)
+}
+
+// MultiStatusError is returned when a request affecting several resources
+// fails for some of them (207 Multi-Status). It unwraps to the errors of
+// the individual resources, so errors.Is works as for single resources.
+type MultiStatusError struct {
+	Method string
+	Path   string
+	Errors []*ResourceError
+}
+
+func (e *MultiStatusError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, re := range e.Errors {
+		msgs[i] = re.Error()
+	}
+	return fmt.Sprintf("webdav %s %s: %d resources failed: %s", e.Method, e.Path, len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed resources
+func (e *MultiStatusError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, re := range e.Errors {
+		errs[i] = re
+	}
+	return errs
+}
+
+// ResourceError is the failure of one resource within a multistatus response
+type ResourceError struct {
+	Href       string
+	StatusCode int
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("%s: status %d %s", e.Href, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap returns the os error corresponding to the status code, such as
+// os.ErrPermission for 403 Forbidden
+func (e *ResourceError) Unwrap() error {
+	return errors.Unwrap(httpStatusToOSError(e.StatusCode, e.Href))
+}
+
+// copy performs a COPY request
+func (c *webdavClient) copy(ctx context.Context, src, dst string, opts CopyOptions) error {
+	defer c.invalidate(dst)
+
+	destURL, err := c.buildURL(dst)
+	if err != nil {
+		return err
+	}
+
+	depth := "infinity"
+	if opts.Shallow {
+		depth = "0"
+	}
+	overwrite := "F"
+	if opts.Overwrite {
+		overwrite = "T"
+	}
+
+	headers := map[string]string{
+		"Destination": destURL.String(),
+		"Depth":       depth,
+		"Overwrite":   overwrite,
+	}
+	headers = c.setIfHeader(headers, dst)
+
+	resp, err := c.doRequest(ctx, "COPY", src, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusMultiStatus:
+		return parseMultiStatusError(resp, "COPY", src)
+	case http.StatusPreconditionFailed:
+		// The destination exists and Overwrite was F
+		return &os.PathError{Op: "copy", Path: dst, Err: os.ErrExist}
+	default:
+		return c.statusError(ctx, resp.StatusCode, src)
+	}
+}
+
+// parseMultiStatusError collects the failed resources of a 207 response to
+// a request that does not return properties
+func parseMultiStatusError(resp *http.Response, method, pathStr string) error {
+	ms, err := parseMultistatus(resp.Body)
+	if err != nil {
+		return &os.PathError{Op: strings.ToLower(method), Path: pathStr, Err: err}
+	}
+
+	mse := &MultiStatusError{Method: method, Path: pathStr}
+	for _, r := range ms.Responses {
+		code := r.statusCode()
+		if code < 300 {
+			continue
+		}
+		// One response may report the same status for several resources
+		for _, href := range r.Hrefs {
+			mse.Errors = append(mse.Errors, &ResourceError{Href: href, StatusCode: code})
+		}
+	}
+	if len(mse.Errors) == 0 {
+		return nil
+	}
+	return mse
+}
+
+// parseStatusLine extracts the code from a status such as
+// "HTTP/1.1 403 Forbidden"
+func parseStatusLine(s string) (int, bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return 0, false
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// Interface compliance check
+var _ Copier = (*FileSystem)(nil)
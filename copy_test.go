@@ -0,0 +1,123 @@
+package webdavfs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFileSystem_Copy(t *testing.T) {
+	server := newLockingServer(t)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := fs.Mkdir("/src", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := fs.WriteFile("/src/a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := fs.Copy("/src", "/dst", CopyOptions{}); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	got, err := fs.ReadFile("/dst/a.txt")
+	if err != nil || string(got) != "alpha" {
+		t.Errorf("ReadFile() of copy = %q, %v; want %q", got, err, "alpha")
+	}
+
+	// Shallow copies the collection without its members
+	if err := fs.Copy("/src", "/empty", CopyOptions{Shallow: true}); err != nil {
+		t.Fatalf("Copy(Shallow) error = %v", err)
+	}
+	if _, err := fs.Stat("/empty/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat() in shallow copy error = %v, want not exist", err)
+	}
+
+	if err := fs.Copy("/src/a.txt", "/dst/a.txt", CopyOptions{}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Copy() onto existing file error = %v, want os.ErrExist", err)
+	}
+
+	if err := fs.WriteFile("/b.txt", []byte("beta"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := fs.Copy("/b.txt", "/dst/a.txt", CopyOptions{Overwrite: true}); err != nil {
+		t.Fatalf("Copy(Overwrite) error = %v", err)
+	}
+	got, err = fs.ReadFile("/dst/a.txt")
+	if err != nil || string(got) != "beta" {
+		t.Errorf("ReadFile() after overwrite = %q, %v; want %q", got, err, "beta")
+	}
+
+	if err := fs.Copy("/missing", "/x", CopyOptions{}); !os.IsNotExist(err) {
+		t.Errorf("Copy() of missing source error = %v, want not exist", err)
+	}
+}
+
+func TestFileSystem_CopyMultiStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "COPY" || r.Header.Get("Depth") != "infinity" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/dst/private</D:href>
+    <D:status>HTTP/1.1 403 Forbidden</D:status>
+  </D:response>
+  <D:response>
+    <D:href>/dst/big.bin</D:href>
+    <D:status>HTTP/1.1 507 Insufficient Storage</D:status>
+  </D:response>
+</D:multistatus>`))
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	var c Copier = fs
+	err = c.Copy("/src", "/dst", CopyOptions{})
+	var mse *MultiStatusError
+	if !errors.As(err, &mse) {
+		t.Fatalf("Copy() error = %v, want *MultiStatusError", err)
+	}
+	if len(mse.Errors) != 2 {
+		t.Fatalf("got %d resource errors, want 2", len(mse.Errors))
+	}
+	if mse.Errors[0].Href != "/dst/private" || mse.Errors[0].StatusCode != http.StatusForbidden {
+		t.Errorf("Errors[0] = %+v", mse.Errors[0])
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Error("MultiStatusError should match the errors of its resources")
+	}
+}
+
+func TestParseStatusLine(t *testing.T) {
+	tests := []struct {
+		line string
+		code int
+		ok   bool
+	}{
+		{"HTTP/1.1 403 Forbidden", 403, true},
+		{"HTTP/1.1 200", 200, true},
+		{"HTTP/1.1", 0, false},
+		{"garbage here", 0, false},
+	}
+
+	for _, tt := range tests {
+		code, ok := parseStatusLine(tt.line)
+		if code != tt.code || ok != tt.ok {
+			t.Errorf("parseStatusLine(%q) = %d, %v; want %d, %v", tt.line, code, ok, tt.code, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package webdavfs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Property is a WebDAV property of a resource together with the status the
+// server reported for it
+type Property struct {
+	Name xml.Name
+
+	// Value is the text content of the property
+	Value string
+
+	// Status is the HTTP status of the property, such as 200 when it was
+	// found or 404 when the resource does not have it
+	Status int
+}
+
+// PropertyError is returned when the server rejects a property update.
+// PROPPATCH is atomic, so none of the changes were applied. Properties that
+// only failed because another one did are reported with 424 Failed
+// Dependency.
+type PropertyError struct {
+	Path   string
+	Status map[xml.Name]int
+}
+
+func (e *PropertyError) Error() string {
+	names := sortedNames(e.Status)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: status %d", propName(name), e.Status[name])
+	}
+	return fmt.Sprintf("webdav PROPPATCH %s: %s", e.Path, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the os errors corresponding to the statuses of the
+// properties that caused the failure
+func (e *PropertyError) Unwrap() []error {
+	var errs []error
+	for _, name := range sortedNames(e.Status) {
+		if code := e.Status[name]; code != http.StatusFailedDependency {
+			errs = append(errs, errors.Unwrap(httpStatusToOSError(code, e.Path)))
+		}
+	}
+	return errs
+}
+
+// GetProperties returns the named properties of a file or directory,
+// including those the server reports as missing (Status 404). Without names
+// it returns all properties the server is willing to list (allprop), which
+// usually includes the dead properties set with SetProperties.
+func (fs *FileSystem) GetProperties(name string, names ...xml.Name) (map[xml.Name]Property, error) {
+	name = fs.cleanPath(name)
+	body := buildAllpropBody()
+	if len(names) > 0 {
+		body = buildPropBody(names)
+	}
+	return fs.client.getProperties(fs.context(), name, body)
+}
+
+// PropertyNames returns the names of all properties of a file or directory
+// (propname)
+func (fs *FileSystem) PropertyNames(name string) ([]xml.Name, error) {
+	name = fs.cleanPath(name)
+	props, err := fs.client.getProperties(fs.context(), name, buildPropnameBody())
+	if err != nil {
+		return nil, err
+	}
+	return sortedNames(props), nil
+}
+
+// SetProperties sets properties of a file or directory to the given text
+// values. Either all of them are set, or none and a *PropertyError reports
+// the status of each property.
+func (fs *FileSystem) SetProperties(name string, props map[xml.Name]string) error {
+	name = fs.cleanPath(name)
+	if len(props) == 0 {
+		return nil
+	}
+	return fs.client.patchProperties(fs.context(), name, buildPropertyUpdateBody(props, nil))
+}
+
+// RemoveProperties removes properties from a file or directory. Removing a
+// property the resource does not have is not an error.
+func (fs *FileSystem) RemoveProperties(name string, names ...xml.Name) error {
+	name = fs.cleanPath(name)
+	if len(names) == 0 {
+		return nil
+	}
+	return fs.client.patchProperties(fs.context(), name, buildPropertyUpdateBody(nil, names))
+}
+
+// propertyMultistatus is a multistatus response decoded without assuming
+// which properties it holds
+type propertyMultistatus struct {
+	Responses []struct {
+		Hrefs     []string           `xml:"DAV: href"`
+		Status    string             `xml:"DAV: status"`
+		Propstats []propertyPropstat `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propertyPropstat is a propstat element of a propertyMultistatus
+type propertyPropstat struct {
+	Prop struct {
+		Props []extraProp `xml:",any"`
+	} `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+// properties returns the properties of the first response, keyed by name.
+// For a Depth 0 request that is the requested resource.
+func (ms *propertyMultistatus) properties() map[xml.Name]Property {
+	props := make(map[xml.Name]Property)
+	if len(ms.Responses) == 0 {
+		return props
+	}
+	for _, ps := range ms.Responses[0].Propstats {
+		code, _ := parseStatusLine(ps.Status)
+		for _, p := range ps.Prop.Props {
+			props[p.XMLName] = Property{
+				Name:   p.XMLName,
+				Value:  strings.TrimSpace(p.Value),
+				Status: code,
+			}
+		}
+	}
+	return props
+}
+
+// getProperties sends a PROPFIND with body and reports the properties of
+// pathStr
+func (c *webdavClient) getProperties(ctx context.Context, pathStr, body string) (map[xml.Name]Property, error) {
+	resp, err := c.propfindRequest(ctx, pathStr, 0, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms propertyMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &os.PathError{Op: "propfind", Path: pathStr, Err: contextError(ctx, err)}
+	}
+	if len(ms.Responses) > 0 {
+		if code, ok := parseStatusLine(ms.Responses[0].Status); ok && code >= 300 {
+			return nil, c.statusError(ctx, code, pathStr)
+		}
+	}
+	return ms.properties(), nil
+}
+
+// patchProperties sends a PROPPATCH with body and fails unless every
+// property was updated
+func (c *webdavClient) patchProperties(ctx context.Context, pathStr, body string) error {
+	defer c.invalidate(pathStr)
+
+	headers := map[string]string{
+		"Content-Type": "application/xml",
+	}
+	headers = c.setIfHeader(headers, pathStr)
+
+	resp, err := c.doRequest(ctx, "PROPPATCH", pathStr, strings.NewReader(body), headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return c.statusError(ctx, resp.StatusCode, pathStr)
+	}
+
+	var ms propertyMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return &os.PathError{Op: "proppatch", Path: pathStr, Err: contextError(ctx, err)}
+	}
+
+	failed := &PropertyError{Path: pathStr, Status: make(map[xml.Name]int)}
+	for name, p := range ms.properties() {
+		if p.Status >= 300 {
+			failed.Status[name] = p.Status
+		}
+	}
+	if len(failed.Status) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// buildPropBody creates a PROPFIND request body for the named properties
+func buildPropBody(names []xml.Name) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:">
+  <D:prop>
+`)
+	for i, name := range names {
+		b.WriteString("    " + propElement(name, i) + "\n")
+	}
+	b.WriteString(`  </D:prop>
+</D:propfind>`)
+	return b.String()
+}
+
+// buildAllpropBody creates a PROPFIND request body for all properties
+func buildAllpropBody() string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:">
+  <D:allprop/>
+</D:propfind>`
+}
+
+// buildPropnameBody creates a PROPFIND request body for the property names
+func buildPropnameBody() string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:">
+  <D:propname/>
+</D:propfind>`
+}
+
+// buildPropertyUpdateBody creates a PROPPATCH request body that sets and
+// removes properties
+func buildPropertyUpdateBody(set map[xml.Name]string, remove []xml.Name) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:">
+`)
+	i := 0
+	if len(set) > 0 {
+		b.WriteString("  <D:set>\n    <D:prop>\n")
+		for _, name := range sortedNames(set) {
+			b.WriteString("      " + propValueElement(name, i, set[name]) + "\n")
+			i++
+		}
+		b.WriteString("    </D:prop>\n  </D:set>\n")
+	}
+	if len(remove) > 0 {
+		b.WriteString("  <D:remove>\n    <D:prop>\n")
+		for _, name := range remove {
+			b.WriteString("      " + propElement(name, i) + "\n")
+			i++
+		}
+		b.WriteString("    </D:prop>\n  </D:remove>\n")
+	}
+	b.WriteString(`</D:propertyupdate>`)
+	return b.String()
+}
+
+// sortedNames returns the keys of m ordered by namespace and local name
+func sortedNames[V any](m map[xml.Name]V) []xml.Name {
+	names := make([]xml.Name, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+	return names
+}
+
+// propName formats name in Clark notation, {namespace}local
+func propName(name xml.Name) string {
+	return "{" + name.Space + "}" + name.Local
+}
@@ -0,0 +1,147 @@
+package webdavfs
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileSystem_Properties(t *testing.T) {
+	server := newLockingServer(t)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.WriteFile("/photo.jpg", []byte("jpeg"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	tags := xml.Name{Space: "urn:example:meta", Local: "tags"}
+	sum := xml.Name{Space: "urn:example:meta", Local: "sha256"}
+	missing := xml.Name{Space: "urn:example:meta", Local: "missing"}
+
+	err = fs.SetProperties("/photo.jpg", map[xml.Name]string{
+		tags: "holiday <beach> & sun",
+		sum:  "abc123",
+	})
+	if err != nil {
+		t.Fatalf("SetProperties() error = %v", err)
+	}
+
+	props, err := fs.GetProperties("/photo.jpg", tags, missing)
+	if err != nil {
+		t.Fatalf("GetProperties() error = %v", err)
+	}
+	if p := props[tags]; p.Status != http.StatusOK || p.Value != "holiday <beach> & sun" {
+		t.Errorf("tags = %+v", p)
+	}
+	if p := props[missing]; p.Status != http.StatusNotFound {
+		t.Errorf("missing = %+v, want status 404", p)
+	}
+	if _, ok := props[sum]; ok {
+		t.Error("GetProperties() returned a property that was not requested")
+	}
+
+	all, err := fs.GetProperties("/photo.jpg")
+	if err != nil {
+		t.Fatalf("GetProperties() (allprop) error = %v", err)
+	}
+	if all[sum].Value != "abc123" {
+		t.Errorf("allprop sha256 = %+v", all[sum])
+	}
+
+	names, err := fs.PropertyNames("/photo.jpg")
+	if err != nil {
+		t.Fatalf("PropertyNames() error = %v", err)
+	}
+	found := false
+	for _, name := range names {
+		found = found || name == tags
+	}
+	if !found {
+		t.Errorf("PropertyNames() = %v, missing %v", names, tags)
+	}
+
+	if err := fs.RemoveProperties("/photo.jpg", tags); err != nil {
+		t.Fatalf("RemoveProperties() error = %v", err)
+	}
+	props, err = fs.GetProperties("/photo.jpg", tags)
+	if err != nil {
+		t.Fatalf("GetProperties() error = %v", err)
+	}
+	if props[tags].Status != http.StatusNotFound {
+		t.Errorf("tags after RemoveProperties = %+v, want status 404", props[tags])
+	}
+
+	if _, err := fs.GetProperties("/nope.jpg", tags); !os.IsNotExist(err) {
+		t.Errorf("GetProperties() of missing file error = %v, want not exist", err)
+	}
+}
+
+func TestFileSystem_SetPropertiesFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:" xmlns:M="urn:example:meta">
+  <D:response>
+    <D:href>/file.txt</D:href>
+    <D:propstat>
+      <D:prop><D:getetag/></D:prop>
+      <D:status>HTTP/1.1 403 Forbidden</D:status>
+    </D:propstat>
+    <D:propstat>
+      <D:prop><M:tags/></D:prop>
+      <D:status>HTTP/1.1 424 Failed Dependency</D:status>
+    </D:propstat>
+  </D:response>
+</D:multistatus>`))
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	tags := xml.Name{Space: "urn:example:meta", Local: "tags"}
+	etag := xml.Name{Space: "DAV:", Local: "getetag"}
+	err = fs.SetProperties("/file.txt", map[xml.Name]string{tags: "x", etag: "y"})
+	var pe *PropertyError
+	if !errors.As(err, &pe) {
+		t.Fatalf("SetProperties() error = %v, want *PropertyError", err)
+	}
+	if pe.Status[etag] != http.StatusForbidden || pe.Status[tags] != http.StatusFailedDependency {
+		t.Errorf("Status = %v", pe.Status)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Error("PropertyError should match the error of the rejected property")
+	}
+}
+
+func TestBuildPropertyUpdateBody(t *testing.T) {
+	tags := xml.Name{Space: "urn:example:meta", Local: "tags"}
+	body := buildPropertyUpdateBody(
+		map[xml.Name]string{tags: "a<b"},
+		[]xml.Name{{Space: "urn:other", Local: "old"}},
+	)
+
+	for _, want := range []string{
+		`<x0:tags xmlns:x0="urn:example:meta">a&lt;b</x0:tags>`,
+		`<D:remove>`,
+		`<x1:old xmlns:x1="urn:other"/>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q:\n%s", want, body)
+		}
+	}
+
+	var v struct{}
+	if err := xml.Unmarshal([]byte(body), &v); err != nil {
+		t.Errorf("body is not well-formed XML: %v", err)
+	}
+}
@@ -1,10 +1,29 @@
 package webdavfs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 )
 
+// ErrConflict is matched by errors reporting that a conditional write was
+// rejected because the resource changed on the server since it was read
+var ErrConflict = errors.New("resource changed on the server")
+
+// ErrPinMismatch is matched by errors reporting that the server's
+// certificate chain does not contain any of the public keys pinned in
+// TLSConfig.PinnedPublicKeys
+var ErrPinMismatch = errors.New("server certificate does not match any pinned public key")
+
+// ErrInsufficientStorage is matched by errors reporting that the server has
+// no room for the data (507 Insufficient Storage), or that the available
+// quota is too small for an upload checked with Config.QuotaCheck
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// ErrTooManyRedirects is matched by errors reporting that a request was
+// redirected more than Config.MaxRedirects times
+var ErrTooManyRedirects = errors.New("too many redirects")
+
 // ConfigError represents an error in the configuration
 type ConfigError struct {
 	Field  string
@@ -48,12 +67,30 @@ func httpStatusToOSError(statusCode int, path string) error {
 		return &os.PathError{Op: "access", Path: path, Err: os.ErrPermission}
 	case 507:
 		// Insufficient Storage
-		return &os.PathError{Op: "write", Path: path, Err: fmt.Errorf("insufficient storage")}
+		return &os.PathError{Op: "write", Path: path, Err: ErrInsufficientStorage}
 	default:
 		return &os.PathError{Op: "webdav", Path: path, Err: fmt.Errorf("http status %d", statusCode)}
 	}
 }
 
+// PreconditionError is returned when a write conditioned on an ETag is
+// rejected because the resource no longer has that ETag (412 Precondition
+// Failed), or when a cached read finds that the file changed. It matches
+// ErrConflict.
+type PreconditionError struct {
+	Path string
+	ETag string // The ETag the write or read expected
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("precondition failed: %s changed on the server (expected ETag %s)", e.Path, e.ETag)
+}
+
+// Is reports whether target is ErrConflict
+func (e *PreconditionError) Is(target error) bool {
+	return target == ErrConflict
+}
+
 // FileClosedError is returned when an operation is attempted on a closed file
 type FileClosedError struct {
 	Path string
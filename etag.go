@@ -0,0 +1,126 @@
+package webdavfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+)
+
+// precondition restricts a write to a known state of the resource, so that
+// concurrent writers do not silently overwrite each other
+type precondition struct {
+	ifMatch     string // Strong ETag the resource must still have
+	ifNoneMatch bool   // The resource must not exist yet
+}
+
+// active reports whether the precondition restricts anything
+func (p precondition) active() bool {
+	return p.ifMatch != "" || p.ifNoneMatch
+}
+
+// setHeaders adds the conditional request headers for p
+func (p precondition) setHeaders(headers map[string]string) {
+	if p.ifMatch != "" {
+		headers["If-Match"] = p.ifMatch
+	}
+	if p.ifNoneMatch {
+		headers["If-None-Match"] = "*"
+	}
+}
+
+// failed returns the error reported when the server rejects p with 412
+func (p precondition) failed(pathStr string) error {
+	if p.ifNoneMatch {
+		return &os.PathError{Op: "create", Path: pathStr, Err: os.ErrExist}
+	}
+	return &PreconditionError{Path: pathStr, ETag: p.ifMatch}
+}
+
+// checkPrecondition verifies p against the current state of pathStr. It is
+// used where the server cannot evaluate the precondition itself, and unlike
+// a conditional request it does not exclude a concurrent change right after.
+func (c *webdavClient) checkPrecondition(ctx context.Context, pathStr string, p precondition) error {
+	if !p.active() {
+		return nil
+	}
+
+	info, err := c.statUncached(ctx, pathStr)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if p.ifNoneMatch && info != nil {
+		return p.failed(pathStr)
+	}
+	if p.ifMatch != "" && (info == nil || etagOf(info) != p.ifMatch) {
+		return p.failed(pathStr)
+	}
+	return nil
+}
+
+// ifMatch returns a precondition requiring the resource to still have etag.
+// Weak ETags never match If-Match, so they impose no precondition.
+func ifMatch(etag string) precondition {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return precondition{}
+	}
+	return precondition{ifMatch: etag}
+}
+
+// normalizeETag returns etag as a quoted entity tag. Some servers report
+// getetag without the quotes required in HTTP headers.
+func normalizeETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// etagOf returns the ETag recorded in info, if any
+func etagOf(info os.FileInfo) string {
+	if fi, ok := info.(*fileInfo); ok && fi.res != nil {
+		return fi.res.ETag
+	}
+	return ""
+}
+
+// WriteFileIfMatch writes data to name only if the file still has the given
+// ETag, as returned by File.ETag, and returns the new ETag. If the file was
+// changed or removed in the meantime, the error matches ErrConflict and the
+// file is left untouched.
+func (fs *FileSystem) WriteFileIfMatch(name string, data []byte, etag string) (string, error) {
+	name = fs.cleanPath(name)
+	if etag == "" {
+		return "", &os.PathError{Op: "write", Path: name, Err: os.ErrInvalid}
+	}
+	return fs.writeFileCond(name, data, precondition{ifMatch: normalizeETag(etag)})
+}
+
+// WriteFileIfNotExist creates name with data only if it does not exist yet,
+// and returns the ETag of the new file. If the file exists, the error
+// matches os.ErrExist and the file is left untouched.
+func (fs *FileSystem) WriteFileIfNotExist(name string, data []byte) (string, error) {
+	name = fs.cleanPath(name)
+	return fs.writeFileCond(name, data, precondition{ifNoneMatch: true})
+}
+
+// writeFileCond uploads data to name under the precondition p and returns
+// the new ETag
+func (fs *FileSystem) writeFileCond(name string, data []byte, p precondition) (string, error) {
+	ctx := fs.context()
+	if err := fs.client.checkQuota(ctx, name, int64(len(data))); err != nil {
+		return "", err
+	}
+	etag, err := fs.client.upload(ctx, name, bytes.NewReader(data), int64(len(data)), p)
+	if err != nil {
+		return "", err
+	}
+	if etag == "" {
+		// The server did not report the new ETag with the response
+		if info, err := fs.client.stat(ctx, name); err == nil {
+			etag = etagOf(info)
+		}
+	}
+	return etag, nil
+}
@@ -0,0 +1,153 @@
+package webdavfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestETag_ConcurrentWritersConflict(t *testing.T) {
+	server := newStatefulMockServer()
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := fs.WriteFile("/shared.txt", []byte("original"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	a, err := fs.OpenFile("/shared.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	b, err := fs.OpenFile("/shared.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if a.(*File).ETag() == "" {
+		t.Fatal("ETag() is empty for a file the server reports an ETag for")
+	}
+
+	if _, err := a.WriteAt([]byte("AAAA"), 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() of first writer error = %v", err)
+	}
+
+	// The second writer still holds the original version
+	if _, err := b.WriteAt([]byte("BBBB"), 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	err = b.Close()
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Close() of second writer error = %v, want ErrConflict", err)
+	}
+	var pe *PreconditionError
+	if !errors.As(err, &pe) || pe.Path != "/shared.txt" {
+		t.Errorf("expected *PreconditionError for /shared.txt, got %v", err)
+	}
+
+	got, err := fs.ReadFile("/shared.txt")
+	if err != nil || string(got) != "AAAAinal" {
+		t.Errorf("ReadFile() = %q, %v; want %q", got, err, "AAAAinal")
+	}
+}
+
+func TestETag_SyncTracksNewVersion(t *testing.T) {
+	server := newStatefulMockServer()
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := fs.WriteFile("/doc.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	f, err := fs.OpenFile("/doc.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	defer f.Close()
+	file := f.(*File)
+
+	before := file.ETag()
+	for i, s := range []string{"a", "b"} {
+		if _, err := f.WriteAt([]byte(s), int64(i)); err != nil {
+			t.Fatalf("WriteAt() error = %v", err)
+		}
+		// Each Sync is conditioned on the version written by the previous one
+		if err := f.Sync(); err != nil {
+			t.Fatalf("Sync() #%d error = %v", i+1, err)
+		}
+	}
+	if file.ETag() == before || file.ETag() == "" {
+		t.Errorf("ETag() = %q after Sync, want a new ETag (was %q)", file.ETag(), before)
+	}
+}
+
+func TestFileSystem_WriteFileIfMatch(t *testing.T) {
+	server := newStatefulMockServer()
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	etag, err := fs.WriteFileIfNotExist("/cas.txt", []byte("v1"))
+	if err != nil {
+		t.Fatalf("WriteFileIfNotExist() error = %v", err)
+	}
+	if etag == "" {
+		t.Fatal("WriteFileIfNotExist() returned an empty ETag")
+	}
+	if _, err := fs.WriteFileIfNotExist("/cas.txt", []byte("again")); !errors.Is(err, os.ErrExist) {
+		t.Errorf("WriteFileIfNotExist() on existing file error = %v, want os.ErrExist", err)
+	}
+
+	etag2, err := fs.WriteFileIfMatch("/cas.txt", []byte("v2"), etag)
+	if err != nil {
+		t.Fatalf("WriteFileIfMatch() error = %v", err)
+	}
+	if etag2 == etag {
+		t.Error("WriteFileIfMatch() returned the old ETag")
+	}
+
+	// The first ETag is stale now
+	if _, err := fs.WriteFileIfMatch("/cas.txt", []byte("v3"), etag); !errors.Is(err, ErrConflict) {
+		t.Errorf("WriteFileIfMatch() with stale ETag error = %v, want ErrConflict", err)
+	}
+
+	got, err := fs.ReadFile("/cas.txt")
+	if err != nil || string(got) != "v2" {
+		t.Errorf("ReadFile() = %q, %v; want %q", got, err, "v2")
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		etag string
+		want string
+	}{
+		{`"abc"`, `"abc"`},
+		{`W/"abc"`, ""}, // Weak ETags never match If-Match
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ifMatch(tt.etag).ifMatch; got != tt.want {
+			t.Errorf("ifMatch(%q) = %q, want %q", tt.etag, got, tt.want)
+		}
+	}
+
+	if got := normalizeETag("abc"); got != `"abc"` {
+		t.Errorf("normalizeETag(%q) = %q, want %q", "abc", got, `"abc"`)
+	}
+}
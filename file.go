@@ -1,7 +1,7 @@
 package webdavfs
 
 import (
-	"bytes"
+	"errors"
 	"io"
 	iofs "io/fs"
 	"os"
@@ -11,17 +11,18 @@ import (
 
 // File represents an open file in the WebDAV filesystem
 type File struct {
-	fs       *FileSystem
-	path     string
-	flag     int
-	offset   int64
-	info     os.FileInfo
-	buffer   *bytes.Buffer // For write buffering
-	modified bool
-	closed   bool
-	reader   io.ReadCloser // For reading
-	dirIndex int           // For directory iteration
-	dirInfos []os.FileInfo // Cached directory contents
+	fs     *FileSystem
+	path   string
+	flag   int
+	offset int64
+	info   os.FileInfo
+	etag   string     // ETag of the content last read or written
+	wb     *writeBack // Pending modifications, written back on Sync/Close
+	upload uploader   // Streaming upload for sequential writers
+	lock   *Lock      // Lock held until Close, with O_LOCK
+	closed bool
+	reader io.ReadCloser // For reading
+	dir    *dirReader    // For directory iteration
 }
 
 // Read reads data from the file
@@ -40,9 +41,19 @@ func (f *File) Read(b []byte) (int, error) {
 		return 0, &os.PathError{Op: "read", Path: f.path, Err: os.ErrInvalid}
 	}
 
+	// Read back local modifications
+	if f.wb != nil {
+		if err := f.wb.ensureLoaded(f.fs.context(), f.fs.client, f.path); err != nil {
+			return 0, err
+		}
+		n, err := f.wb.readAt(b, f.offset)
+		f.offset += int64(n)
+		return n, err
+	}
+
 	// Initialize reader if needed
 	if f.reader == nil {
-		reader, err := f.fs.client.get(f.path, f.offset)
+		reader, err := f.fs.client.get(f.fs.context(), f.path, f.offset)
 		if err != nil {
 			return 0, err
 		}
@@ -51,6 +62,9 @@ func (f *File) Read(b []byte) (int, error) {
 
 	n, err := f.reader.Read(b)
 	f.offset += int64(n)
+	if err != nil && err != io.EOF {
+		err = &os.PathError{Op: "read", Path: f.path, Err: contextError(f.fs.context(), err)}
+	}
 	return n, err
 }
 
@@ -70,18 +84,127 @@ func (f *File) Write(b []byte) (int, error) {
 		return 0, &os.PathError{Op: "write", Path: f.path, Err: os.ErrInvalid}
 	}
 
-	// Initialize buffer if needed
-	if f.buffer == nil {
-		f.buffer = &bytes.Buffer{}
+	// Sequential writers stream straight to the server
+	if f.upload == nil && f.canStream() {
+		f.upload = f.fs.client.startStreamUpload(f.fs.context(), f.path, ifMatch(f.etag))
+	}
+	if f.upload != nil {
+		n, err := f.upload.Write(b)
+		f.offset += int64(n)
+		if err != nil {
+			return n, &os.PathError{Op: "write", Path: f.path, Err: contextError(f.fs.context(), err)}
+		}
+		return n, nil
 	}
 
-	n, err := f.buffer.Write(b)
-	if err != nil {
-		return n, err
+	if err := f.ensureWriteBack(); err != nil {
+		return 0, err
 	}
 
+	// In append mode every write goes to the current end of the file
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = f.wb.size
+	}
+
+	n, err := f.wb.writeAt(b, f.offset)
 	f.offset += int64(n)
-	f.modified = true
+	if err != nil {
+		return n, &os.PathError{Op: "write", Path: f.path, Err: err}
+	}
+	return n, nil
+}
+
+// ensureWriteBack sets up the write-back state on the first modification.
+// The original content is only downloaded once it is actually needed.
+func (f *File) ensureWriteBack() error {
+	if f.wb != nil {
+		return nil
+	}
+
+	wb, err := newWriteBack(f.info.Size(), f.fs.spoolThreshold, f.fs.spoolDir)
+	if err != nil {
+		return &os.PathError{Op: "write", Path: f.path, Err: err}
+	}
+
+	// Reads are served from the working copy from now on
+	if f.reader != nil {
+		f.reader.Close()
+		f.reader = nil
+	}
+
+	f.wb = wb
+	return nil
+}
+
+// canStream reports whether writes can be streamed to the server as a single
+// PUT instead of being buffered until Close. This holds for files opened
+// write-only and truncated, as long as nothing has been written yet.
+func (f *File) canStream() bool {
+	return f.flag&os.O_WRONLY != 0 &&
+		f.flag&os.O_TRUNC != 0 &&
+		f.flag&os.O_APPEND == 0 &&
+		f.offset == 0 &&
+		f.wb == nil
+}
+
+// ReadFrom copies r into the file until EOF. For files that can be streamed,
+// the data flows from r to the server without being buffered in memory.
+// This implements io.ReaderFrom.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	if f.closed {
+		return 0, &FileClosedError{Path: f.path}
+	}
+
+	if f.upload == nil && f.canStream() && !f.info.IsDir() {
+		if err := f.fs.client.checkQuota(f.fs.context(), f.path, declaredSize(r)); err != nil {
+			return 0, err
+		}
+		f.upload = f.fs.client.startStreamUpload(f.fs.context(), f.path, ifMatch(f.etag))
+	}
+	if f.upload == nil {
+		// Hide ReadFrom so io.Copy does not call back into it
+		return io.Copy(struct{ io.Writer }{f}, r)
+	}
+
+	n, err := io.Copy(f.upload, r)
+	f.offset += n
+	if err != nil {
+		return n, &os.PathError{Op: "write", Path: f.path, Err: contextError(f.fs.context(), err)}
+	}
+	return n, nil
+}
+
+// WriteTo copies the file from the current offset to w until EOF, streaming
+// the response body directly into w. This implements io.WriterTo.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if f.closed {
+		return 0, &FileClosedError{Path: f.path}
+	}
+
+	if f.flag&os.O_WRONLY != 0 || f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.path, Err: os.ErrInvalid}
+	}
+
+	src := f.reader
+	if f.wb != nil {
+		if err := f.wb.ensureLoaded(f.fs.context(), f.fs.client, f.path); err != nil {
+			return 0, err
+		}
+		src = io.NopCloser(io.NewSectionReader(f.wb.spool, f.offset, max(f.wb.size-f.offset, 0)))
+	} else if src == nil {
+		reader, err := f.fs.client.get(f.fs.context(), f.path, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.reader = reader
+		src = reader
+	}
+
+	n, err := io.Copy(w, src)
+	f.offset += n
+	if err != nil {
+		return n, &os.PathError{Op: "read", Path: f.path, Err: contextError(f.fs.context(), err)}
+	}
 	return n, nil
 }
 
@@ -97,15 +220,28 @@ func (f *File) Close() error {
 	if f.reader != nil {
 		f.reader.Close()
 	}
+	if f.dir != nil {
+		f.dir.close()
+	}
+
+	var err error
+	if f.upload != nil {
+		// Complete a streaming upload and report the server's response
+		_, err = f.upload.finish()
+	} else if f.wb != nil {
+		// Write back local modifications
+		_, err = f.wb.flush(f.fs.context(), f.fs.client, f.path, ifMatch(f.etag))
+		f.wb.close()
+	}
 
-	// Flush writes if modified
-	if f.modified && f.buffer != nil {
-		if err := f.fs.client.put(f.path, f.buffer); err != nil {
-			return err
+	// Release the lock only once the data is on the server
+	if f.lock != nil {
+		if uerr := f.lock.Unlock(); err == nil {
+			err = uerr
 		}
 	}
 
-	return nil
+	return err
 }
 
 // Seek sets the offset for the next Read or Write
@@ -121,9 +257,13 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		newOffset = f.offset + offset
 	case io.SeekEnd:
+		if f.wb != nil {
+			newOffset = f.wb.size + offset
+			break
+		}
 		if f.info == nil {
 			var err error
-			f.info, err = f.fs.client.stat(f.path)
+			f.info, err = f.fs.client.stat(f.fs.context(), f.path)
 			if err != nil {
 				return 0, err
 			}
@@ -137,6 +277,11 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 		return 0, &InvalidSeekError{Offset: offset, Whence: whence}
 	}
 
+	// A streaming upload can only move forward by writing
+	if f.upload != nil && newOffset != f.offset {
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.ErrUnsupported}
+	}
+
 	// If we have an active reader and offset changed, close it
 	if f.reader != nil && newOffset != f.offset {
 		f.reader.Close()
@@ -153,11 +298,18 @@ func (f *File) Stat() (os.FileInfo, error) {
 		return nil, &FileClosedError{Path: f.path}
 	}
 
+	// Report the size of the working copy while it has local modifications
+	if fi, ok := f.info.(*fileInfo); ok && f.wb != nil {
+		local := *fi
+		local.size = f.wb.size
+		return &local, nil
+	}
+
 	if f.info != nil {
 		return f.info, nil
 	}
 
-	info, err := f.fs.client.stat(f.path)
+	info, err := f.fs.client.stat(f.fs.context(), f.path)
 	if err != nil {
 		return nil, err
 	}
@@ -177,13 +329,40 @@ func (f *File) ReadAt(b []byte, off int64) (int, error) {
 		return 0, &os.PathError{Op: "read", Path: f.path, Err: os.ErrInvalid}
 	}
 
-	reader, err := f.fs.client.get(f.path, off)
+	if f.wb != nil {
+		if err := f.wb.ensureLoaded(f.fs.context(), f.fs.client, f.path); err != nil {
+			return 0, err
+		}
+		return f.wb.readAt(b, off)
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.path, Err: errors.New("negative offset")}
+	}
+
+	// Only content identified by a strong ETag can be cached safely
+	ctx := f.fs.context()
+	if cache := f.fs.client.blocks; cache != nil && f.info != nil && ifMatch(f.etag).active() {
+		return cache.readAt(ctx, f.fs.client, f.path, f.etag, f.info.Size(), b, off)
+	}
+
+	// Request exactly the bytes needed, so that small reads stay small
+	reader, _, err := f.fs.client.getRange(ctx, f.path, off, int64(len(b)))
 	if err != nil {
 		return 0, err
 	}
 	defer reader.Close()
 
-	return io.ReadFull(reader, b)
+	n, err := io.ReadFull(reader, b)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		// Like io.ReaderAt, report a short read at the end of the file as EOF
+		err = io.EOF
+	default:
+		err = &os.PathError{Op: "read", Path: f.path, Err: contextError(ctx, err)}
+	}
+	return n, err
 }
 
 // WriteAt writes to the file at a specific offset
@@ -197,15 +376,36 @@ func (f *File) WriteAt(b []byte, off int64) (int, error) {
 		return 0, &os.PathError{Op: "write", Path: f.path, Err: os.ErrInvalid}
 	}
 
-	// Use putRange for partial updates
-	if err := f.fs.client.putRange(f.path, b, off); err != nil {
+	// Like os.File, refuse positioned writes in append mode
+	if f.flag&os.O_APPEND != 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.path, Err: errors.New("invalid use of WriteAt on file opened with O_APPEND")}
+	}
+
+	// A streaming upload cannot be modified in place
+	if f.upload != nil {
+		return 0, &os.PathError{Op: "write", Path: f.path, Err: errors.ErrUnsupported}
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.path, Err: os.ErrInvalid}
+	}
+
+	if err := f.ensureWriteBack(); err != nil {
 		return 0, err
 	}
 
-	return len(b), nil
+	n, err := f.wb.writeAt(b, off)
+	if err != nil {
+		return n, &os.PathError{Op: "write", Path: f.path, Err: err}
+	}
+	return n, nil
 }
 
-// Readdir reads directory contents
+// Readdir reads directory contents. With n > 0, the listing is decoded
+// from the server's response only as far as needed, so that huge
+// directories can be paged through in bounded memory; the response stays
+// open until the last entry is read or the file is closed. Such paged
+// listings bypass the metadata cache.
 func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 	if f.closed {
 		return nil, &FileClosedError{Path: f.path}
@@ -215,40 +415,35 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 		return nil, &os.PathError{Op: "readdir", Path: f.path, Err: os.ErrInvalid}
 	}
 
-	// Load directory contents if not cached
-	if f.dirInfos == nil {
-		infos, err := f.fs.client.readDir(f.path)
-		if err != nil {
+	if f.dir == nil {
+		if err := f.openDir(n); err != nil {
 			return nil, err
 		}
-		f.dirInfos = infos
-		f.dirIndex = 0
 	}
+	return f.dir.read(n)
+}
 
-	// Return all remaining entries if n <= 0
-	if n <= 0 {
-		result := f.dirInfos[f.dirIndex:]
-		f.dirIndex = len(f.dirInfos)
-		if len(result) == 0 {
-			return nil, io.EOF
+// openDir starts reading the directory. A complete listing is read at once
+// through the metadata cache; a partial one is streamed.
+func (f *File) openDir(n int) error {
+	ctx := f.fs.context()
+	if infos, ok := f.fs.client.cache.listing(f.path); ok || n <= 0 {
+		if !ok {
+			var err error
+			if infos, err = f.fs.client.readDir(ctx, f.path); err != nil {
+				return err
+			}
 		}
-		return result, nil
-	}
-
-	// Return n entries
-	if f.dirIndex >= len(f.dirInfos) {
-		return nil, io.EOF
+		f.dir = &dirReader{infos: infos}
+		return nil
 	}
 
-	end := f.dirIndex + n
-	if end > len(f.dirInfos) {
-		end = len(f.dirInfos)
+	lister, err := f.fs.client.listDir(ctx, f.path)
+	if err != nil {
+		return err
 	}
-
-	result := f.dirInfos[f.dirIndex:end]
-	f.dirIndex = end
-
-	return result, nil
+	f.dir = &dirReader{lister: lister}
+	return nil
 }
 
 // Readdirnames reads directory entry names
@@ -277,54 +472,16 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 // In this case, if ReadDir succeeds (reads all the way to the end of the
 // directory), it returns the slice and a nil error.
 func (f *File) ReadDir(n int) ([]iofs.DirEntry, error) {
-	if f.closed {
-		return nil, &FileClosedError{Path: f.path}
-	}
-
-	if !f.info.IsDir() {
-		return nil, &os.PathError{Op: "readdir", Path: f.path, Err: os.ErrInvalid}
-	}
-
-	// Load directory contents if not cached
-	if f.dirInfos == nil {
-		infos, err := f.fs.client.readDir(f.path)
-		if err != nil {
-			return nil, err
-		}
-		f.dirInfos = infos
-		f.dirIndex = 0
-	}
-
-	// Return all remaining entries if n <= 0
-	if n <= 0 {
-		result := make([]iofs.DirEntry, len(f.dirInfos)-f.dirIndex)
-		for i, info := range f.dirInfos[f.dirIndex:] {
-			result[i] = dirEntry{info}
-		}
-		f.dirIndex = len(f.dirInfos)
-		if len(result) == 0 {
-			return nil, io.EOF
-		}
-		return result, nil
-	}
-
-	// Return n entries
-	if f.dirIndex >= len(f.dirInfos) {
-		return nil, io.EOF
-	}
-
-	end := f.dirIndex + n
-	if end > len(f.dirInfos) {
-		end = len(f.dirInfos)
+	infos, err := f.Readdir(n)
+	if err != nil {
+		return nil, err
 	}
 
-	result := make([]iofs.DirEntry, end-f.dirIndex)
-	for i, info := range f.dirInfos[f.dirIndex:end] {
-		result[i] = dirEntry{info}
+	entries := make([]iofs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = dirEntry{info}
 	}
-	f.dirIndex = end
-
-	return result, nil
+	return entries, nil
 }
 
 // Truncate changes the size of the file
@@ -338,38 +495,59 @@ func (f *File) Truncate(size int64) error {
 		return &os.PathError{Op: "truncate", Path: f.path, Err: os.ErrInvalid}
 	}
 
-	// If truncating to 0, just clear the buffer
-	if size == 0 {
-		if f.buffer != nil {
-			f.buffer.Reset()
-		} else {
-			f.buffer = &bytes.Buffer{}
-		}
-		f.modified = true
-		return nil
+	// A streaming upload cannot be modified in place
+	if f.upload != nil {
+		return &os.PathError{Op: "truncate", Path: f.path, Err: errors.ErrUnsupported}
 	}
 
-	// For other sizes, we need to read current content and resize
-	// This is a simplified implementation - servers may not support this
-	return &os.PathError{Op: "truncate", Path: f.path, Err: os.ErrInvalid}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.path, Err: os.ErrInvalid}
+	}
+
+	if err := f.ensureWriteBack(); err != nil {
+		return err
+	}
+	return f.wb.truncate(size)
 }
 
-// Sync flushes buffered writes
+// Sync flushes buffered writes. Data written through a streaming upload is
+// already on its way to the server and is committed by Close.
+//
+// If the file had an ETag when it was opened, the write only succeeds while
+// the server still holds that version; otherwise the error matches
+// ErrConflict and nothing is overwritten.
 func (f *File) Sync() error {
 	if f.closed {
 		return &FileClosedError{Path: f.path}
 	}
 
-	if f.modified && f.buffer != nil {
-		if err := f.fs.client.put(f.path, f.buffer); err != nil {
-			return err
-		}
-		f.modified = false
+	if f.wb == nil || !f.wb.changed {
+		return nil
+	}
+
+	ctx := f.fs.context()
+	etag, err := f.wb.flush(ctx, f.fs.client, f.path, ifMatch(f.etag))
+	if err != nil {
+		return err
 	}
 
+	// Later writes are conditioned on the version just written
+	if etag == "" && f.etag != "" {
+		if info, err := f.fs.client.stat(ctx, f.path); err == nil {
+			etag = etagOf(info)
+		}
+	}
+	f.etag = etag
 	return nil
 }
 
+// ETag returns the entity tag of the file as last read from or written to
+// the server, or "" if the server does not report one. It can be passed to
+// FileSystem.WriteFileIfMatch.
+func (f *File) ETag() string {
+	return f.etag
+}
+
 // Name returns the file name
 func (f *File) Name() string {
 	return f.path
@@ -380,5 +558,7 @@ func (f *File) WriteString(s string) (int, error) {
 	return f.Write([]byte(s))
 }
 
-// Interface compliance check
+// Interface compliance checks
 var _ absfs.File = (*File)(nil)
+var _ io.ReaderFrom = (*File)(nil)
+var _ io.WriterTo = (*File)(nil)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -58,8 +59,7 @@ func TestWebDAVFS(t *testing.T) {
 		},
 	}
 
-	// Run the test suite with some known skips
-	// Skip Truncate test as webdavfs only supports truncate to 0, not arbitrary sizes
+	// Run the test suite
 	suite.Run(t)
 }
 
@@ -69,19 +69,25 @@ type statefulMockServer struct {
 	files   map[string][]byte
 	dirs    map[string]bool
 	modTime map[string]time.Time
+	version map[string]int // Bumped on every write, reported as the ETag
 }
 
 func newStatefulMockServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(newStatefulMock().handler))
+}
+
+func newStatefulMock() *statefulMockServer {
 	mock := &statefulMockServer{
 		files:   make(map[string][]byte),
 		dirs:    make(map[string]bool),
 		modTime: make(map[string]time.Time),
+		version: make(map[string]int),
 	}
 	// Pre-create /tmp as a directory
 	mock.dirs["/tmp"] = true
 	mock.dirs["/"] = true
 
-	return httptest.NewServer(http.HandlerFunc(mock.handler))
+	return mock
 }
 
 func (m *statefulMockServer) handler(w http.ResponseWriter, r *http.Request) {
@@ -193,6 +199,7 @@ func (m *statefulMockServer) handlePropfind(w http.ResponseWriter, r *http.Reque
         <D:displayname>` + rel + `</D:displayname>
         <D:getcontentlength>` + fmt.Sprintf("%d", len(content)) + `</D:getcontentlength>
         <D:getlastmodified>` + m.modTime[childPath].Format(time.RFC1123) + `</D:getlastmodified>
+        <D:getetag>` + m.etag(childPath) + `</D:getetag>
         <D:resourcetype/>
       </D:prop>
       <D:status>HTTP/1.1 200 OK</D:status>
@@ -247,6 +254,7 @@ func (m *statefulMockServer) handlePropfind(w http.ResponseWriter, r *http.Reque
         <D:displayname>` + basename + `</D:displayname>
         <D:getcontentlength>` + fmt.Sprintf("%d", len(content)) + `</D:getcontentlength>
         <D:getlastmodified>` + modTime.Format(time.RFC1123) + `</D:getlastmodified>
+        <D:getetag>` + m.etag(path) + `</D:getetag>
         <D:resourcetype/>
       </D:prop>
       <D:status>HTTP/1.1 200 OK</D:status>
@@ -291,9 +299,21 @@ func (m *statefulMockServer) handlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, exists := m.files[path]
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != m.etag(path)) {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
 	m.files[path] = content
 	m.modTime[path] = time.Now()
+	m.version[path]++
 
+	w.Header().Set("ETag", m.etag(path))
 	w.WriteHeader(201)
 }
 
@@ -328,6 +348,15 @@ func (m *statefulMockServer) handleDelete(w http.ResponseWriter, r *http.Request
 	delete(m.files, path)
 	delete(m.dirs, path)
 	delete(m.modTime, path)
+	if strings.HasSuffix(path, "/") {
+		// Collection URLs also remove their members
+		for childPath := range m.files {
+			if strings.HasPrefix(childPath, path) {
+				delete(m.files, childPath)
+				delete(m.modTime, childPath)
+			}
+		}
+	}
 	m.mu.Unlock()
 
 	w.WriteHeader(204)
@@ -343,10 +372,16 @@ func (m *statefulMockServer) handleMove(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	if strings.HasSuffix(oldPath, "/.file") {
+		m.assembleChunks(w, r, strings.TrimSuffix(oldPath, ".file"), newPath)
+		return
+	}
+
 	m.mu.Lock()
 	if content, exists := m.files[oldPath]; exists {
 		m.files[newPath] = content
 		m.modTime[newPath] = m.modTime[oldPath]
+		m.version[newPath] = m.version[oldPath] + 1
 		delete(m.files, oldPath)
 		delete(m.modTime, oldPath)
 	} else if m.dirs[oldPath] {
@@ -360,6 +395,44 @@ func (m *statefulMockServer) handleMove(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(201)
 }
 
+// assembleChunks emulates the final MOVE of a Nextcloud chunked upload by
+// joining the numbered chunks in dir into the file at newPath
+func (m *statefulMockServer) assembleChunks(w http.ResponseWriter, r *http.Request, dir, newPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirs[dir] {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var content []byte
+	for part := 1; ; part++ {
+		chunk, ok := m.files[dir+strconv.Itoa(part)]
+		if !ok {
+			break
+		}
+		content = append(content, chunk...)
+		delete(m.files, dir+strconv.Itoa(part))
+	}
+	delete(m.dirs, dir)
+
+	if total := r.Header.Get("OC-Total-Length"); total != strconv.Itoa(len(content)) {
+		http.Error(w, "Bad Request - length mismatch", http.StatusBadRequest)
+		return
+	}
+
+	m.files[newPath] = content
+	m.modTime[newPath] = time.Now()
+	m.version[newPath]++
+	w.WriteHeader(201)
+}
+
+// etag returns the current entity tag of a file
+func (m *statefulMockServer) etag(path string) string {
+	return fmt.Sprintf(`"v%d"`, m.version[path])
+}
+
 func (m *statefulMockServer) handleProppatch(w http.ResponseWriter, r *http.Request) {
 	// For Chtimes support - just accept it
 	w.WriteHeader(207)
@@ -56,6 +56,84 @@ func FuzzXMLParsing(f *testing.F) {
   </D:response>
 </D:multistatus>`)
 
+	// Seed with a 200 propstat followed by a 404 propstat for missing
+	// properties, whose empty elements must not erase the values found
+	f.Add(`<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>/docs/report.pdf</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getcontentlength>2048</d:getcontentlength>
+        <d:getlastmodified>Tue, 02 Jan 2024 10:00:00 GMT</d:getlastmodified>
+        <d:resourcetype/>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop>
+        <d:getcontentlength/>
+        <d:getlastmodified/>
+        <d:creationdate/>
+      </d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`)
+	// Seed with percent-encoded and absolute URL hrefs
+	f.Add(`<?xml version="1.0"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>https://cloud.example.com/remote.php/dav/my%20file%25.txt</D:href>
+    <D:propstat>
+      <D:prop><D:getcontentlength>5</D:getcontentlength></D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+  <D:response>
+    <D:href>/dav/%E6%96%87%E4%BB%B6/</D:href>
+    <D:propstat>
+      <D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+  <D:response>
+    <D:href>/dav/bad%zzescape</D:href>
+    <D:propstat><D:prop/><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+  </D:response>
+</D:multistatus>`)
+	// Seed with per-response statuses, as for members a PROPFIND cannot
+	// list, and a status shared by several hrefs
+	f.Add(`<?xml version="1.0"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/dir/private/</D:href>
+    <D:status>HTTP/1.1 403 Forbidden</D:status>
+  </D:response>
+  <D:response>
+    <D:href>/dir/a</D:href>
+    <D:href>/dir/b</D:href>
+    <D:status>HTTP/1.1 423 Locked</D:status>
+    <D:responsedescription>locked by another user</D:responsedescription>
+  </D:response>
+</D:multistatus>`)
+	// Seed with other prefixes, a default DAV: namespace and a property of
+	// another namespace sharing a DAV: name
+	f.Add(`<?xml version="1.0"?>
+<multistatus xmlns="DAV:" xmlns:lp1="DAV:" xmlns:x="urn:example">
+  <response>
+    <href>/dir/file.txt</href>
+    <propstat>
+      <prop>
+        <lp1:getcontentlength>7</lp1:getcontentlength>
+        <x:getcontentlength>999</x:getcontentlength>
+        <lp1:resourcetype/>
+      </prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`)
+
 	f.Fuzz(func(t *testing.T, xmlData string) {
 		var ms multistatus
 		// Should not panic on any XML input
@@ -65,18 +143,26 @@ func FuzzXMLParsing(f *testing.F) {
 		if err == nil {
 			for _, resp := range ms.Responses {
 				// Access all fields without panicking
-				_ = resp.Href
-				_ = resp.Propstat.Prop.GetContentLength
-				_ = resp.Propstat.Prop.GetLastModified
-				_ = resp.Propstat.Prop.DisplayName
-				_ = resp.Propstat.Prop.ResourceType.Collection
-				_ = resp.Propstat.Prop.GetETag
-				_ = resp.Propstat.Prop.GetContentType
-				_ = resp.Propstat.Prop.CreationDate
-				_ = resp.Propstat.Status
+				_ = resp.href()
+				_ = resp.statusCode()
+				for _, ps := range resp.Propstats {
+					_ = ps.ok()
+				}
+				p := resp.props()
+				_ = p.GetContentLength
+				_ = p.GetLastModified
+				_ = p.DisplayName
+				_ = p.ResourceType.Collection
+				_ = p.GetETag
+				_ = p.GetContentType
+				_ = p.CreationDate
 
 				// Test parseFileInfo with the response
-				_, _ = parseFileInfo(resp, "/test")
+				info, err := parseFileInfo(resp, "/test")
+				if err == nil && (info.Name() == "" || strings.Contains(info.Name(), "/")) {
+					t.Errorf("parseFileInfo() name = %q, want a single path element", info.Name())
+				}
+				_, _ = relativeHref(&url.URL{Path: "/dav/"}, resp.href())
 			}
 		}
 
@@ -253,11 +339,11 @@ func FuzzPropertyValues(f *testing.F) {
 
 		// Test parseFileInfo with this prop
 		resp := response{
-			Href: "/test.txt",
-			Propstat: propstat{
+			Hrefs: []string{"/test.txt"},
+			Propstats: []propstat{{
 				Prop:   p,
 				Status: "HTTP/1.1 200 OK",
-			},
+			}},
 		}
 		_, _ = parseFileInfo(resp, "/test")
 	})
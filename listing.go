@@ -0,0 +1,152 @@
+package webdavfs
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// dirLister reads the Depth: 1 listing of a directory from the PROPFIND
+// response as it arrives, so that only the entries asked for are held in
+// memory
+type dirLister struct {
+	ctx    context.Context
+	path   string
+	dirURL *url.URL
+	body   io.ReadCloser
+	ms     *multistatusReader
+	first  bool        // Whether no response has been read yet
+	self   os.FileInfo // The directory itself, once its response was read
+	err    error       // Sticky error, io.EOF at the end of the listing
+}
+
+// listDir starts listing the directory pathStr
+func (c *webdavClient) listDir(ctx context.Context, pathStr string) (*dirLister, error) {
+	dirURL, err := c.buildURL(pathStr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.propfindRequest(ctx, pathStr, 1, buildPropfindBody(c.extraProps...))
+	if err != nil {
+		return nil, err
+	}
+	return &dirLister{
+		ctx:    ctx,
+		path:   pathStr,
+		dirURL: dirURL,
+		body:   resp.Body,
+		ms:     newMultistatusReader(resp.Body),
+		first:  true,
+	}, nil
+}
+
+// isEmptyDir reports whether the directory pathStr has no members. It asks
+// the server rather than the metadata cache, and stops reading the listing
+// at the first member.
+func (c *webdavClient) isEmptyDir(ctx context.Context, pathStr string) (bool, error) {
+	if !strings.HasSuffix(pathStr, "/") {
+		pathStr += "/"
+	}
+	l, err := c.listDir(ctx, pathStr)
+	if err != nil {
+		return false, err
+	}
+	defer l.close()
+
+	infos, err := l.next(1)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return len(infos) == 0, nil
+}
+
+// next returns up to n further entries, or all remaining ones if n <= 0.
+// At the end of the listing it returns io.EOF along with the last entries,
+// and the response body is closed.
+func (l *dirLister) next(n int) ([]os.FileInfo, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	var infos []os.FileInfo
+	for n <= 0 || len(infos) < n {
+		resp, err := l.ms.next()
+		if err != nil {
+			if err != io.EOF {
+				err = &os.PathError{Op: "propfind", Path: l.path, Err: contextError(l.ctx, err)}
+			}
+			l.err = err
+			l.body.Close()
+			return infos, err
+		}
+
+		first := l.first
+		l.first = false
+		rel, inside := relativeHref(l.dirURL, resp.href())
+		// One response is the directory itself, usually the first. Servers
+		// behind a proxy may report hrefs outside the base URL, in which
+		// case the first is assumed.
+		if (inside && rel == "") || (first && (!inside || strings.Contains(rel, "/"))) {
+			l.self, _ = parseFileInfo(resp, l.path)
+			continue
+		}
+
+		info, err := parseFileInfo(resp, l.path)
+		if err != nil {
+			continue // Skip members reported with an error status, e.g. 403
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// close abandons the rest of the listing
+func (l *dirLister) close() {
+	if l.err == nil {
+		l.err = io.EOF
+		l.body.Close()
+	}
+}
+
+// dirReader hands out the entries of an open directory in batches. The
+// entries come from a complete listing, or are decoded from the server's
+// response only as they are asked for.
+type dirReader struct {
+	infos  []os.FileInfo // Entries read but not yet handed out
+	lister *dirLister    // The rest of the listing, nil once read completely
+}
+
+// read returns up to n entries, or all remaining ones if n <= 0. It
+// returns io.EOF if there are none left.
+func (d *dirReader) read(n int) ([]os.FileInfo, error) {
+	if d.lister != nil && (n <= 0 || len(d.infos) < n) {
+		more, err := d.lister.next(n - len(d.infos))
+		d.infos = append(d.infos, more...)
+		if err == io.EOF {
+			d.lister = nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(d.infos) == 0 {
+		return nil, io.EOF
+	}
+	k := len(d.infos)
+	if n > 0 && n < k {
+		k = n
+	}
+	result := d.infos[:k:k]
+	d.infos = d.infos[k:]
+	return result, nil
+}
+
+// close abandons the rest of the listing
+func (d *dirReader) close() {
+	if d.lister != nil {
+		d.lister.close()
+		d.lister = nil
+	}
+}
@@ -0,0 +1,207 @@
+package webdavfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultistatusReader(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response><D:href>/a</D:href></D:response>
+  <D:responsedescription>partial listing</D:responsedescription>
+  <D:response><D:href>/b</D:href><D:href>/c</D:href></D:response>
+</D:multistatus>`
+	mr := newMultistatusReader(strings.NewReader(body))
+	var hrefs []string
+	for {
+		resp, err := mr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		hrefs = append(hrefs, strings.Join(resp.Hrefs, ","))
+	}
+	if got := strings.Join(hrefs, " "); got != "/a /b,/c" {
+		t.Errorf("responses = %q, want %q", got, "/a /b,/c")
+	}
+
+	for _, bad := range []string{
+		``,
+		`<D:multistatus xmlns:D="DAV:"><D:response><D:href>/a</D:href></D:response>`,
+		`<multistatus><response><href>/a</href></response></multistatus>`,
+		`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`,
+	} {
+		mr := newMultistatusReader(strings.NewReader(bad))
+		var err error
+		for err == nil {
+			_, err = mr.next()
+		}
+		if err == io.EOF {
+			t.Errorf("reading %q ended with io.EOF, want an error", bad)
+		}
+	}
+}
+
+// newStreamingListServer serves a listing of /big with total files. The
+// response is flushed after the first batch of entries, and the rest is
+// only sent once release is closed.
+func newStreamingListServer(t *testing.T, total, first int, release chan struct{}) (*httptest.Server, chan error) {
+	t.Helper()
+	done := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			http.Error(w, "unexpected", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Depth") == "0" {
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<D:multistatus xmlns:D="DAV:"><D:response><D:href>/big/</D:href>
+<D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response></D:multistatus>`)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0"?><D:multistatus xmlns:D="DAV:">
+<D:response><D:href>/big/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+		for i := 0; i < total; i++ {
+			if i == first {
+				w.(http.Flusher).Flush()
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					done <- r.Context().Err()
+					return
+				}
+			}
+			_, err := fmt.Fprintf(w, `<D:response><D:href>/big/file%06d.jpg</D:href><D:propstat><D:prop><D:getcontentlength>%d</D:getcontentlength><D:resourcetype/></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`, i, i)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		fmt.Fprint(w, `</D:multistatus>`)
+		done <- nil
+	}))
+	t.Cleanup(server.Close)
+	return server, done
+}
+
+func TestFile_ReaddirPaged(t *testing.T) {
+	release := make(chan struct{})
+	server, done := newStreamingListServer(t, 5000, 20, release)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	f, err := fs.Open("/big")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	// The first page arrives before the server has sent the rest
+	page, err := f.Readdir(10)
+	if err != nil || len(page) != 10 {
+		t.Fatalf("Readdir(10) = %d entries, %v; want 10", len(page), err)
+	}
+	if page[0].Name() != "file000000.jpg" || page[9].Size() != 9 {
+		t.Errorf("first page = %s .. %s (%d bytes)", page[0].Name(), page[9].Name(), page[9].Size())
+	}
+	close(release)
+
+	seen := len(page)
+	for {
+		names, err := f.Readdirnames(999)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdirnames() error = %v", err)
+		}
+		if len(names) > 999 {
+			t.Fatalf("Readdirnames(999) returned %d names", len(names))
+		}
+		if want := fmt.Sprintf("file%06d.jpg", seen); names[0] != want {
+			t.Fatalf("page starts with %s, want %s", names[0], want)
+		}
+		seen += len(names)
+	}
+	if seen != 5000 {
+		t.Errorf("listed %d entries, want 5000", seen)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("server error = %v", err)
+	}
+}
+
+func TestFile_ReaddirPagedClose(t *testing.T) {
+	release := make(chan struct{})
+	server, done := newStreamingListServer(t, 100, 5, release)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	f, err := fs.Open("/big")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	entries, err := f.ReadDir(3)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("ReadDir(3) = %d entries, %v; want 3", len(entries), err)
+	}
+
+	// Closing the directory abandons the rest of the response
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("server sent the whole listing after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request still open after Close")
+	}
+	if _, err := f.ReadDir(3); err == nil {
+		t.Error("ReadDir() after Close succeeded")
+	}
+}
+
+func TestFile_ReaddirAllAtOnce(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	server, _ := newStreamingListServer(t, 50, 0, release)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	f, err := fs.Open("/big")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	page, err := f.Readdir(7)
+	if err != nil || len(page) != 7 {
+		t.Fatalf("Readdir(7) = %d entries, %v; want 7", len(page), err)
+	}
+	rest, err := f.Readdir(0)
+	if err != nil || len(rest) != 43 {
+		t.Fatalf("Readdir(0) = %d entries, %v; want the remaining 43", len(rest), err)
+	}
+	if _, err := f.Readdir(1); err != io.EOF {
+		t.Errorf("Readdir(1) at the end = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,548 @@
+package webdavfs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// O_LOCK is an OpenFile flag that takes an exclusive WebDAV lock on the file
+// for the lifetime of the returned File. The lock is released by Close.
+// It is ignored by other absfs filesystems.
+const O_LOCK = 1 << 30
+
+// defaultLockTimeout is the lock duration requested when none is given
+const defaultLockTimeout = 10 * time.Minute
+
+// errLockLost reports that the server no longer holds a lock
+var errLockLost = errors.New("lock lost")
+
+// LockOptions configures a lock taken with FileSystem.Lock
+type LockOptions struct {
+	// Shared requests a shared lock instead of an exclusive one
+	Shared bool
+
+	// Recursive locks a collection together with all of its members
+	// (Depth: infinity). Otherwise only the resource itself is locked.
+	Recursive bool
+
+	// Owner describes the lock holder to other clients (optional)
+	Owner string
+
+	// Timeout is the lock duration requested from the server
+	// (default: 10 minutes). The server may grant a different one.
+	// A negative value asks for a lock that never expires.
+	Timeout time.Duration
+
+	// NoRefresh disables the background renewal of the lock. The caller is
+	// then responsible for calling Refresh before the lock expires.
+	NoRefresh bool
+}
+
+// Lock is a WebDAV lock held by the client. While it is held, requests that
+// modify the locked resource submit its token in an If header. Unless
+// disabled, the lock is renewed in the background before it expires.
+type Lock struct {
+	client    *webdavClient
+	ctx       context.Context // Without cancellation, so the lock outlives it
+	path      string
+	recursive bool
+	token     string
+	requested time.Duration // Timeout asked for on every refresh
+
+	mu       sync.Mutex
+	timeout  time.Duration // Negative for infinite
+	err      error         // Last background refresh failure
+	released bool          // Whether Unlock was called
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// LockedError is returned when the server refuses a request because the
+// resource is locked by someone else (423 Locked). Owner is empty when the
+// server does not disclose the lock holder. It matches os.ErrPermission.
+type LockedError struct {
+	Path  string
+	Owner string
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner == "" {
+		return fmt.Sprintf("resource locked: %s", e.Path)
+	}
+	return fmt.Sprintf("resource locked: %s (owner: %s)", e.Path, e.Owner)
+}
+
+// Is reports whether target is os.ErrPermission, which locked resources were
+// reported as before LockedError existed
+func (e *LockedError) Is(target error) bool {
+	return target == os.ErrPermission
+}
+
+// Lock takes a WebDAV lock on name. Locking a path that does not exist
+// creates an empty file on most servers. Release the lock with Unlock.
+func (fs *FileSystem) Lock(name string, opts LockOptions) (*Lock, error) {
+	name = fs.cleanPath(name)
+	return fs.client.lock(fs.context(), name, opts)
+}
+
+// Token returns the lock token assigned by the server
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Path returns the locked path
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Timeout returns the lock duration last granted by the server, or a
+// negative duration if the lock does not expire
+func (l *Lock) Timeout() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timeout
+}
+
+// Err returns the error of the last failed background refresh, or nil
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Refresh renews the lock for the originally requested timeout
+func (l *Lock) Refresh() error {
+	return l.refresh(l.ctx)
+}
+
+// Unlock releases the lock and stops its background refresh. The token is
+// no longer sent with requests, even if the server fails to release it.
+// The lock is released even if the context it was taken with has ended.
+// Calling Unlock again has no effect.
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	released := l.released
+	l.released = true
+	l.mu.Unlock()
+	if released {
+		return nil
+	}
+	defer l.client.invalidate(l.path)
+
+	l.client.locks.remove(l)
+	l.stopRefresh()
+
+	resp, err := l.client.doRequest(l.ctx, "UNLOCK", l.path, nil, map[string]string{
+		"Lock-Token": "<" + l.token + ">",
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return httpStatusToOSError(resp.StatusCode, l.path)
+	}
+
+	return nil
+}
+
+// lock sends a LOCK request for pathStr and registers the resulting lock
+func (c *webdavClient) lock(ctx context.Context, pathStr string, opts LockOptions) (*Lock, error) {
+	defer c.invalidate(pathStr) // The lock may create the resource
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = defaultLockTimeout
+	}
+
+	depth := "0"
+	if opts.Recursive {
+		depth = "infinity"
+	}
+
+	headers := map[string]string{
+		"Content-Type": "application/xml",
+		"Depth":        depth,
+		"Timeout":      formatLockTimeout(timeout),
+	}
+
+	if caps := c.caps.known(); caps != nil && !caps.SupportsLocking() {
+		return nil, errLockingUnsupported(pathStr)
+	}
+
+	body := buildLockBody(opts.Shared, opts.Owner)
+	resp, err := c.doRequest(ctx, "LOCK", pathStr, strings.NewReader(body), headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+			if caps, err := c.capabilities(ctx); err == nil && !caps.SupportsLocking() {
+				return nil, errLockingUnsupported(pathStr)
+			}
+		}
+		return nil, c.statusError(ctx, resp.StatusCode, pathStr)
+	}
+
+	token := strings.Trim(strings.TrimSpace(resp.Header.Get("Lock-Token")), "<>")
+	granted, ok := parseLockResponse(resp.Body, token)
+	if token == "" {
+		token = granted.token
+	}
+	if token == "" {
+		return nil, &os.PathError{Op: "lock", Path: pathStr, Err: fmt.Errorf("server returned no lock token")}
+	}
+
+	l := &Lock{
+		client:    c,
+		ctx:       context.WithoutCancel(ctx),
+		path:      pathStr,
+		recursive: opts.Recursive,
+		token:     token,
+		requested: timeout,
+		timeout:   timeout,
+	}
+	if ok {
+		l.timeout = granted.timeout
+	}
+	c.locks.add(l)
+
+	if !opts.NoRefresh && l.timeout > 0 {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.refreshLoop(l.stop)
+	}
+
+	return l, nil
+}
+
+// refresh renews the lock, keeping the timeout the server grants
+func (l *Lock) refresh(ctx context.Context) error {
+	resp, err := l.client.doRequest(ctx, "LOCK", l.path, nil, map[string]string{
+		"If":      "(<" + l.token + ">)",
+		"Timeout": formatLockTimeout(l.requested),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		// The token no longer matches a lock on the server
+		return &os.PathError{Op: "lock", Path: l.path, Err: errLockLost}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return l.client.statusError(ctx, resp.StatusCode, l.path)
+	}
+
+	if granted, ok := parseLockResponse(resp.Body, l.token); ok {
+		l.mu.Lock()
+		l.timeout = granted.timeout
+		l.mu.Unlock()
+	}
+
+	return nil
+}
+
+// refreshLoop renews the lock when half of its timeout has passed, until
+// the lock is released or a refresh fails
+func (l *Lock) refreshLoop(stop <-chan struct{}) {
+	defer close(l.done)
+
+	for {
+		timeout := l.Timeout()
+		if timeout <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(timeout / 2)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := l.refresh(l.ctx); err != nil {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+			return
+		}
+	}
+}
+
+// stopRefresh ends the background refresh and waits for it to finish
+func (l *Lock) stopRefresh() {
+	l.mu.Lock()
+	stop := l.stop
+	l.stop = nil
+	l.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-l.done
+	}
+}
+
+// lockRegistry tracks the locks held by a client so that their tokens can be
+// submitted with requests on the locked resources
+type lockRegistry struct {
+	mu    sync.Mutex
+	locks []*Lock
+}
+
+func (r *lockRegistry) add(l *Lock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.locks = append(r.locks, l)
+}
+
+func (r *lockRegistry) remove(l *Lock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, held := range r.locks {
+		if held == l {
+			r.locks = append(r.locks[:i], r.locks[i+1:]...)
+			return
+		}
+	}
+}
+
+// ifHeader returns the If header value submitting the tokens of every lock
+// that affects a request on the given paths, or "" if there are none. A lock
+// affects a path when it is on the path itself, on a descendant, on the
+// parent (whose membership it protects), or on an ancestor with infinite
+// depth.
+func (r *lockRegistry) ifHeader(paths ...string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.locks) == 0 {
+		return ""
+	}
+
+	var tokens []string
+	for _, l := range r.locks {
+		for _, p := range paths {
+			if lockAffects(l, p) {
+				tokens = append(tokens, "<"+l.token+">")
+				break
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(tokens, " ") + ")"
+}
+
+// lockAffects reports whether l must be submitted to modify pathStr
+func lockAffects(l *Lock, pathStr string) bool {
+	switch {
+	case l.path == pathStr:
+		return true
+	case isAncestor(pathStr, l.path):
+		// Modifying a collection modifies its locked members
+		return true
+	case l.path == path.Dir(pathStr):
+		return true
+	default:
+		return l.recursive && isAncestor(l.path, pathStr)
+	}
+}
+
+// isAncestor reports whether dir is a proper ancestor of pathStr
+func isAncestor(dir, pathStr string) bool {
+	if dir == "/" {
+		return pathStr != "/"
+	}
+	return strings.HasPrefix(pathStr, dir+"/")
+}
+
+// setIfHeader adds the If header for requests modifying the given paths
+func (c *webdavClient) setIfHeader(headers map[string]string, paths ...string) map[string]string {
+	h := c.locks.ifHeader(paths...)
+	if h == "" {
+		return headers
+	}
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["If"] = h
+	return headers
+}
+
+// statusError converts a failed response status into an error. For 423
+// Locked, the server is asked who holds the lock.
+func (c *webdavClient) statusError(ctx context.Context, statusCode int, pathStr string) error {
+	if statusCode != http.StatusLocked {
+		return httpStatusToOSError(statusCode, pathStr)
+	}
+	return &LockedError{Path: pathStr, Owner: c.lockOwner(ctx, pathStr)}
+}
+
+// lockOwner returns the owner of the active lock on pathStr, or "" if it
+// cannot be determined
+func (c *webdavClient) lockOwner(ctx context.Context, pathStr string) string {
+	resp, err := c.doRequest(ctx, "PROPFIND", pathStr, strings.NewReader(buildLockDiscoveryBody()), map[string]string{
+		"Content-Type": "application/xml",
+		"Depth":        "0",
+	})
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return ""
+	}
+
+	var ms struct {
+		Responses []struct {
+			Propstat []struct {
+				Prop lockDiscovery `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ms); err != nil {
+		return ""
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstat {
+			for _, al := range ps.Prop.ActiveLocks {
+				if owner := al.Owner.text(); owner != "" {
+					return owner
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// lockDiscovery is the DAV:lockdiscovery property
+type lockDiscovery struct {
+	ActiveLocks []activeLock `xml:"lockdiscovery>activelock"`
+}
+
+// activeLock describes one lock in a lockdiscovery property
+type activeLock struct {
+	LockScope lockScope `xml:"lockscope"`
+	Depth     string    `xml:"depth"`
+	Owner     lockOwner `xml:"owner"`
+	Timeout   string    `xml:"timeout"`
+	LockToken string    `xml:"locktoken>href"`
+	LockRoot  string    `xml:"lockroot>href"`
+}
+
+// lockOwner holds the free-form DAV:owner element, which is usually either
+// plain text or an href
+type lockOwner struct {
+	Text string `xml:",chardata"`
+	Href string `xml:"href"`
+}
+
+func (o lockOwner) text() string {
+	if s := strings.TrimSpace(o.Href); s != "" {
+		return s
+	}
+	return strings.TrimSpace(o.Text)
+}
+
+// grantedLock is the part of a LOCK response the client keeps
+type grantedLock struct {
+	token   string
+	timeout time.Duration
+}
+
+// parseLockResponse extracts the lock matching token from a LOCK response
+// body. With an empty token, the first lock is used.
+func parseLockResponse(r io.Reader, token string) (grantedLock, bool) {
+	var ld lockDiscovery
+	if err := xml.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&ld); err != nil {
+		return grantedLock{}, false
+	}
+	for _, al := range ld.ActiveLocks {
+		t := strings.TrimSpace(al.LockToken)
+		if token != "" && t != token {
+			continue
+		}
+		timeout, ok := parseLockTimeout(al.Timeout)
+		if !ok {
+			return grantedLock{}, false
+		}
+		return grantedLock{token: t, timeout: timeout}, true
+	}
+	return grantedLock{}, false
+}
+
+// formatLockTimeout formats d as a Timeout header value
+func formatLockTimeout(d time.Duration) string {
+	if d < 0 {
+		return "Infinite"
+	}
+	secs := int64((d + time.Second - 1) / time.Second)
+	return "Second-" + strconv.FormatInt(secs, 10)
+}
+
+// parseLockTimeout parses a DAV:timeout value. Infinite timeouts are
+// returned as a negative duration.
+func parseLockTimeout(s string) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "Infinite") {
+		return -1, true
+	}
+	if len(s) < 7 || !strings.EqualFold(s[:7], "Second-") {
+		return 0, false
+	}
+	secs, err := strconv.ParseInt(s[7:], 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// buildLockBody creates a LOCK request body for a write lock
+func buildLockBody(shared bool, owner string) string {
+	scope := "<D:exclusive/>"
+	if shared {
+		scope = "<D:shared/>"
+	}
+
+	var ownerXML string
+	if owner != "" {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(owner))
+		ownerXML = "\n  <D:owner>" + b.String() + "</D:owner>"
+	}
+
+	return `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope>` + scope + `</D:lockscope>
+  <D:locktype><D:write/></D:locktype>` + ownerXML + `
+</D:lockinfo>`
+}
+
+// buildLockDiscoveryBody creates a PROPFIND request body asking for the
+// active locks of a resource
+func buildLockDiscoveryBody() string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:">
+  <D:prop>
+    <D:lockdiscovery/>
+  </D:prop>
+</D:propfind>`
+}
@@ -0,0 +1,369 @@
+package webdavfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// newLockingServer returns a WebDAV server that enforces locks
+func newLockingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLock_IfHeader(t *testing.T) {
+	server := newLockingServer(t)
+
+	owner, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := owner.WriteFile("/doc.txt", []byte("v1"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	lock, err := owner.Lock("/doc.txt", LockOptions{Owner: "alice"})
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if lock.Token() == "" {
+		t.Error("Lock() returned an empty token")
+	}
+	if lock.Timeout() != defaultLockTimeout {
+		t.Errorf("Timeout() = %v, want %v", lock.Timeout(), defaultLockTimeout)
+	}
+
+	// The lock holder can modify the file
+	if err := owner.WriteFile("/doc.txt", []byte("v2"), 0644); err != nil {
+		t.Errorf("WriteFile() by lock holder error = %v", err)
+	}
+	if err := owner.Chtimes("/doc.txt", time.Now(), time.Now()); err != nil {
+		t.Errorf("Chtimes() by lock holder error = %v", err)
+	}
+
+	// Others cannot
+	err = other.WriteFile("/doc.txt", []byte("v3"), 0644)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("WriteFile() by other client error = %v, want *LockedError", err)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Error("LockedError should match os.ErrPermission")
+	}
+	if err := other.Remove("/doc.txt"); !errors.As(err, &locked) {
+		t.Errorf("Remove() by other client error = %v, want *LockedError", err)
+	}
+
+	if err := lock.Refresh(); err != nil {
+		t.Errorf("Refresh() error = %v", err)
+	}
+
+	if err := owner.Remove("/doc.txt"); err != nil {
+		t.Errorf("Remove() by lock holder error = %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Errorf("Unlock() error = %v", err)
+	}
+	if err := other.WriteFile("/doc.txt", []byte("v4"), 0644); err != nil {
+		t.Errorf("WriteFile() after Unlock error = %v", err)
+	}
+}
+
+func TestLock_OpenFile(t *testing.T) {
+	server := newLockingServer(t)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	f, err := fs.OpenFile("/locked.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC|O_LOCK, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(O_LOCK) error = %v", err)
+	}
+	if _, err := f.Write([]byte("exclusive")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if _, err := other.OpenFile("/locked.txt", os.O_RDWR|O_LOCK, 0); err == nil {
+		t.Error("second OpenFile(O_LOCK) expected error")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Close wrote the data back and released the lock
+	got, err := other.ReadFile("/locked.txt")
+	if err != nil || string(got) != "exclusive" {
+		t.Errorf("ReadFile() = %q, %v; want %q", got, err, "exclusive")
+	}
+	if err := other.Remove("/locked.txt"); err != nil {
+		t.Errorf("Remove() after Close error = %v", err)
+	}
+}
+
+func TestLock_OpenFileExclusive(t *testing.T) {
+	// Like Apache mod_dav, the server creates an empty resource on a LOCK of
+	// a missing file and evaluates If-None-Match
+	mem := webdav.NewMemFS()
+	handler := &webdav.Handler{FileSystem: mem, LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == "*" {
+			if _, err := mem.Stat(r.Context(), r.URL.Path); err == nil {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	flag := os.O_RDWR | os.O_CREATE | os.O_EXCL | O_LOCK
+	f, err := fs.OpenFile("/new.txt", flag, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(O_EXCL|O_LOCK) of a missing file error = %v", err)
+	}
+	if f.(*File).lock == nil {
+		t.Fatal("OpenFile(O_EXCL|O_LOCK) did not lock the file")
+	}
+	if err := other.WriteFile("/new.txt", []byte("intruder"), 0644); err == nil {
+		t.Error("WriteFile() by another client succeeded while the file was locked")
+	}
+	if _, err := f.Write([]byte("mine")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got, err := other.ReadFile("/new.txt"); err != nil || string(got) != "mine" {
+		t.Errorf("ReadFile() = %q, %v; want %q", got, err, "mine")
+	}
+
+	// The file exists now, so another exclusive create fails
+	if _, err := other.OpenFile("/new.txt", flag, 0644); !errors.Is(err, os.ErrExist) {
+		t.Errorf("OpenFile(O_EXCL|O_LOCK) of an existing file error = %v, want ErrExist", err)
+	}
+}
+
+func TestLock_AutoRefresh(t *testing.T) {
+	var mu sync.Mutex
+	refreshes := 0
+
+	locking := newLockingServer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "LOCK" && r.Header.Get("If") != "" {
+			mu.Lock()
+			refreshes++
+			mu.Unlock()
+		}
+		proxyTo(locking.URL, w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	lock, err := fs.Lock("/refreshed.txt", LockOptions{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if err := lock.Unlock(); err != nil {
+		t.Errorf("Unlock() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshes == 0 {
+		t.Error("expected the lock to be refreshed in the background")
+	}
+	if err := lock.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestLock_UnlockAfterCancel(t *testing.T) {
+	var mu sync.Mutex
+	unlocks := 0
+
+	locking := newLockingServer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "UNLOCK" {
+			mu.Lock()
+			unlocks++
+			mu.Unlock()
+		}
+		proxyTo(locking.URL, w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := fs.WithContext(ctx).OpenFile("/doc.txt", os.O_RDWR|os.O_CREATE|O_LOCK, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(O_LOCK) error = %v", err)
+	}
+	lock := f.(*File).lock
+	cancel()
+
+	// The lock is released although its context has ended
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() after cancel error = %v", err)
+	}
+	if err := other.WriteFile("/doc.txt", []byte("free"), 0644); err != nil {
+		t.Errorf("WriteFile() after Unlock error = %v", err)
+	}
+
+	// Unlocking again, also through Close, sends nothing
+	if err := lock.Unlock(); err != nil {
+		t.Errorf("second Unlock() error = %v", err)
+	}
+	f.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if unlocks != 1 {
+		t.Errorf("UNLOCK requests = %d, want 1", unlocks)
+	}
+}
+
+func TestLock_LockedErrorOwner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT":
+			w.WriteHeader(http.StatusLocked)
+		case "PROPFIND":
+			body, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(body), "lockdiscovery") {
+				http.Error(w, "unexpected PROPFIND", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/busy.txt</D:href>
+    <D:propstat>
+      <D:prop>
+        <D:lockdiscovery>
+          <D:activelock>
+            <D:locktype><D:write/></D:locktype>
+            <D:lockscope><D:exclusive/></D:lockscope>
+            <D:owner><D:href>mailto:bob@example.com</D:href></D:owner>
+            <D:timeout>Second-600</D:timeout>
+          </D:activelock>
+        </D:lockdiscovery>
+      </D:prop>
+      <D:status>HTTP/1.1 200 OK</D:status>
+    </D:propstat>
+  </D:response>
+</D:multistatus>`))
+		}
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	err = fs.client.put(fs.context(), "/busy.txt", strings.NewReader("data"))
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("put() error = %v, want *LockedError", err)
+	}
+	if locked.Owner != "mailto:bob@example.com" {
+		t.Errorf("Owner = %q, want %q", locked.Owner, "mailto:bob@example.com")
+	}
+}
+
+func TestParseLockTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"Second-600", 600 * time.Second, true},
+		{"second-5", 5 * time.Second, true},
+		{"Infinite", -1, true},
+		{"Second-", 0, false},
+		{"Minute-5", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseLockTimeout(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseLockTimeout(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLockRegistry_IfHeader(t *testing.T) {
+	var r lockRegistry
+	r.add(&Lock{path: "/dir", token: "urn:dir"})
+	r.add(&Lock{path: "/tree", recursive: true, token: "urn:tree"})
+
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"/dir"}, "(<urn:dir>)"},
+		{[]string{"/dir/new.txt"}, "(<urn:dir>)"},         // Membership of a locked collection
+		{[]string{"/dir/sub/new.txt"}, ""},                // Depth 0 does not reach further
+		{[]string{"/tree/a/b.txt"}, "(<urn:tree>)"},       // Depth infinity does
+		{[]string{"/"}, "(<urn:dir> <urn:tree>)"},         // Both are members of the root
+		{[]string{"/other.txt", "/dir/x"}, "(<urn:dir>)"}, // MOVE into the locked collection
+		{[]string{"/other.txt"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := r.ifHeader(tt.paths...); got != tt.want {
+			t.Errorf("ifHeader(%q) = %q, want %q", tt.paths, got, tt.want)
+		}
+	}
+}
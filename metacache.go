@@ -0,0 +1,226 @@
+package webdavfs
+
+import (
+	"container/list"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// MetadataCacheConfig configures the client-side cache of Stat results and
+// directory listings. Changes made through the FileSystem invalidate the
+// affected entries immediately; changes made by other clients become
+// visible when the entries expire or after FileSystem.InvalidateCache.
+type MetadataCacheConfig struct {
+	// TTL is how long a Stat result or directory listing is reused
+	// (default: 5 seconds)
+	TTL time.Duration
+
+	// NegativeTTL is how long a "does not exist" result is reused
+	// (default: TTL). A negative value disables negative caching.
+	NegativeTTL time.Duration
+
+	// MaxEntries bounds the number of cached Stat results and listings; the
+	// least recently used entries are evicted first (default: 10000)
+	MaxEntries int
+}
+
+// setDefaults sets default values for the cache configuration
+func (mc *MetadataCacheConfig) setDefaults() {
+	if mc.TTL == 0 {
+		mc.TTL = 5 * time.Second
+	}
+	if mc.NegativeTTL == 0 {
+		mc.NegativeTTL = mc.TTL
+	}
+	if mc.MaxEntries == 0 {
+		mc.MaxEntries = 10000
+	}
+}
+
+// validate checks if the cache configuration is valid
+func (mc *MetadataCacheConfig) validate() error {
+	if mc.TTL < 0 {
+		return &ConfigError{Field: "MetadataCache.TTL", Reason: "must not be negative"}
+	}
+	if mc.MaxEntries < 0 {
+		return &ConfigError{Field: "MetadataCache.MaxEntries", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// InvalidateCache discards the cached metadata of name and everything below
+// it, so that changes made by other clients are seen immediately. It has no
+// effect unless Config.MetadataCache is set.
+func (fs *FileSystem) InvalidateCache(name string) {
+	fs.client.cache.invalidate(fs.cleanPath(name))
+}
+
+// cacheKey identifies a cached Stat result or directory listing
+type cacheKey struct {
+	path    string
+	listing bool
+}
+
+// cacheEntry is a cached Stat result or directory listing. A Stat entry
+// without info records that the resource does not exist.
+type cacheEntry struct {
+	key     cacheKey
+	info    os.FileInfo
+	entries []os.FileInfo
+	expires time.Time
+}
+
+// metadataCache caches Stat results and directory listings. A nil
+// *metadataCache caches nothing, so callers need not check whether caching
+// is enabled.
+type metadataCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	negTTL  time.Duration
+	max     int
+	now     func() time.Time
+	entries map[cacheKey]*list.Element
+	lru     *list.List // Front is most recently used
+}
+
+// newMetadataCache returns a cache for the configuration, or nil if config
+// is nil
+func newMetadataCache(config *MetadataCacheConfig) *metadataCache {
+	if config == nil {
+		return nil
+	}
+	return &metadataCache{
+		ttl:     config.TTL,
+		negTTL:  config.NegativeTTL,
+		max:     config.MaxEntries,
+		now:     time.Now,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// stat returns the cached Stat result for pathStr. ok is false if there is
+// none.
+func (mc *metadataCache) stat(pathStr string) (info os.FileInfo, err error, ok bool) {
+	e := mc.lookup(cacheKey{path: path.Clean(pathStr)})
+	if e == nil {
+		return nil, nil, false
+	}
+	if e.info == nil {
+		return nil, &os.PathError{Op: "stat", Path: pathStr, Err: os.ErrNotExist}, true
+	}
+	return e.info, nil, true
+}
+
+// listing returns the cached entries of the directory pathStr
+func (mc *metadataCache) listing(pathStr string) ([]os.FileInfo, bool) {
+	e := mc.lookup(cacheKey{path: path.Clean(pathStr), listing: true})
+	if e == nil {
+		return nil, false
+	}
+	return append([]os.FileInfo(nil), e.entries...), true
+}
+
+// lookup returns the unexpired entry for key and marks it as recently used
+func (mc *metadataCache) lookup(key cacheKey) *cacheEntry {
+	if mc == nil {
+		return nil
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	elem, ok := mc.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*cacheEntry)
+	if !mc.now().Before(e.expires) {
+		mc.remove(elem)
+		return nil
+	}
+	mc.lru.MoveToFront(elem)
+	return e
+}
+
+// putStat records the Stat result for pathStr; a nil info records that it
+// does not exist
+func (mc *metadataCache) putStat(pathStr string, info os.FileInfo) {
+	if mc == nil {
+		return
+	}
+	ttl := mc.ttl
+	if info == nil {
+		if mc.negTTL < 0 {
+			return
+		}
+		ttl = mc.negTTL
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.store(&cacheEntry{key: cacheKey{path: path.Clean(pathStr)}, info: info}, ttl)
+}
+
+// putListing records the listing of the directory pathStr, along with the
+// Stat results of the directory and its entries
+func (mc *metadataCache) putListing(pathStr string, dir os.FileInfo, entries []os.FileInfo) {
+	if mc == nil {
+		return
+	}
+	pathStr = path.Clean(pathStr)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if dir != nil {
+		mc.store(&cacheEntry{key: cacheKey{path: pathStr}, info: dir}, mc.ttl)
+	}
+	for _, info := range entries {
+		mc.store(&cacheEntry{key: cacheKey{path: path.Join(pathStr, info.Name())}, info: info}, mc.ttl)
+	}
+	// Stored last so the child entries are evicted before the listing
+	mc.store(&cacheEntry{key: cacheKey{path: pathStr, listing: true}, entries: entries}, mc.ttl)
+}
+
+// store adds or replaces an entry and evicts the least recently used ones
+// beyond the size bound. mc.mu must be held.
+func (mc *metadataCache) store(e *cacheEntry, ttl time.Duration) {
+	e.expires = mc.now().Add(ttl)
+	if elem, ok := mc.entries[e.key]; ok {
+		elem.Value = e
+		mc.lru.MoveToFront(elem)
+	} else {
+		mc.entries[e.key] = mc.lru.PushFront(e)
+	}
+	for mc.lru.Len() > mc.max {
+		mc.remove(mc.lru.Back())
+	}
+}
+
+// remove drops an entry. mc.mu must be held.
+func (mc *metadataCache) remove(elem *list.Element) {
+	mc.lru.Remove(elem)
+	delete(mc.entries, elem.Value.(*cacheEntry).key)
+}
+
+// invalidate drops everything cached about pathStr and its descendants, as
+// well as the parent directory, whose listing and modification time change
+// along with its members
+func (mc *metadataCache) invalidate(paths ...string) {
+	if mc == nil {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for _, p := range paths {
+		p = path.Clean(p)
+		parent := path.Dir(p)
+		for key, elem := range mc.entries {
+			if key.path == p || key.path == parent || isAncestor(p, key.path) {
+				mc.remove(elem)
+			}
+		}
+	}
+}
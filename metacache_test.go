@@ -0,0 +1,217 @@
+package webdavfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// newCountingServer returns a locking WebDAV server and a counter of the
+// PROPFIND requests it receives
+func newCountingServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var propfinds atomic.Int64
+	handler := &webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" {
+			propfinds.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &propfinds
+}
+
+func TestMetadataCache_Stat(t *testing.T) {
+	server, propfinds := newCountingServer(t)
+
+	fs, err := New(&Config{URL: server.URL, MetadataCache: &MetadataCacheConfig{TTL: time.Minute}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := fs.WriteFile("/a/b/f.txt", []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := fs.Stat("/a/b/f.txt"); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	before := propfinds.Load()
+	info, err := fs.Stat("/a/b/f.txt")
+	if err != nil || info.Size() != 4 {
+		t.Fatalf("Stat() = %v, %v", info, err)
+	}
+	if n := propfinds.Load() - before; n != 0 {
+		t.Errorf("cached Stat() sent %d PROPFINDs", n)
+	}
+
+	// Negative results are cached too
+	if _, err := fs.Stat("/a/missing"); !os.IsNotExist(err) {
+		t.Fatalf("Stat() of missing file error = %v", err)
+	}
+	before = propfinds.Load()
+	if _, err := fs.Stat("/a/missing"); !os.IsNotExist(err) {
+		t.Fatalf("cached Stat() of missing file error = %v", err)
+	}
+	if n := propfinds.Load() - before; n != 0 {
+		t.Errorf("cached negative Stat() sent %d PROPFINDs", n)
+	}
+
+	// Our own changes invalidate the affected entries
+	if err := fs.WriteFile("/a/missing", []byte("now here"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if info, err := fs.Stat("/a/missing"); err != nil || info.Size() != 8 {
+		t.Errorf("Stat() after WriteFile = %v, %v", info, err)
+	}
+	if err := fs.WriteFile("/a/b/f.txt", []byte("longer data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if info, err := fs.Stat("/a/b/f.txt"); err != nil || info.Size() != 11 {
+		t.Errorf("Stat() after overwrite = %v, %v", info, err)
+	}
+
+	if err := fs.Rename("/a/b", "/a/c"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if _, err := fs.Stat("/a/b/f.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat() of moved file error = %v, want not exist", err)
+	}
+	if _, err := fs.Stat("/a/c/f.txt"); err != nil {
+		t.Errorf("Stat() at new location error = %v", err)
+	}
+
+	if err := fs.RemoveAll("/a/c"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := fs.Stat("/a/c/f.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat() of removed file error = %v, want not exist", err)
+	}
+}
+
+func TestMetadataCache_Listing(t *testing.T) {
+	server, propfinds := newCountingServer(t)
+
+	fs, err := New(&Config{URL: server.URL, MetadataCache: &MetadataCacheConfig{TTL: time.Minute}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for _, name := range []string{"/dir/one", "/dir/two"} {
+		if err := fs.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	if _, err := fs.ReadDir("/dir"); err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	before := propfinds.Load()
+	entries, err := fs.ReadDir("/dir")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir() = %v, %v", entries, err)
+	}
+	// The listing populated the Stat results of the entries
+	if info, err := fs.Stat("/dir/two"); err != nil || info.Size() != int64(len("/dir/two")) {
+		t.Errorf("Stat() = %v, %v", info, err)
+	}
+	if n := propfinds.Load() - before; n != 0 {
+		t.Errorf("cached ReadDir() and Stat() sent %d PROPFINDs", n)
+	}
+
+	if err := fs.Remove("/dir/one"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	entries, err = fs.ReadDir("/dir")
+	if err != nil || len(entries) != 1 {
+		t.Errorf("ReadDir() after Remove = %d entries, %v; want 1", len(entries), err)
+	}
+}
+
+func TestFileSystem_InvalidateCache(t *testing.T) {
+	server, _ := newCountingServer(t)
+
+	fs, err := New(&Config{URL: server.URL, MetadataCache: &MetadataCacheConfig{TTL: time.Minute}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if _, err := fs.Stat("/shared.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if err := other.WriteFile("/shared.txt", []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// The change by another client is not seen until the entry is invalidated
+	if _, err := fs.Stat("/shared.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Stat() error = %v, want the cached result", err)
+	}
+	fs.InvalidateCache("/")
+	if _, err := fs.Stat("/shared.txt"); err != nil {
+		t.Errorf("Stat() after InvalidateCache error = %v", err)
+	}
+}
+
+func TestMetadataCache_ExpiryAndEviction(t *testing.T) {
+	now := time.Now()
+	mc := newMetadataCache(&MetadataCacheConfig{TTL: time.Second, NegativeTTL: -1, MaxEntries: 2})
+	mc.now = func() time.Time { return now }
+
+	info := &fileInfo{name: "f"}
+	mc.putStat("/a", info)
+	if got, _, ok := mc.stat("/a"); !ok || got != info {
+		t.Fatal("expected a cache hit")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, _, ok := mc.stat("/a"); ok {
+		t.Error("expected the entry to expire")
+	}
+
+	mc.putStat("/missing", nil)
+	if _, _, ok := mc.stat("/missing"); ok {
+		t.Error("negative result cached although NegativeTTL < 0")
+	}
+
+	mc.putStat("/x", info)
+	mc.putStat("/y", info)
+	mc.stat("/x") // Make /y the least recently used entry
+	mc.putStat("/z", info)
+	if _, _, ok := mc.stat("/y"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, _, ok := mc.stat("/x"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+
+	var disabled *metadataCache
+	disabled.putStat("/a", info)
+	if _, _, ok := disabled.stat("/a"); ok {
+		t.Error("nil cache returned a hit")
+	}
+}
+
+func TestMetadataCacheConfig_Validate(t *testing.T) {
+	_, err := New(&Config{URL: "http://example.com", MetadataCache: &MetadataCacheConfig{TTL: -time.Second}})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("New() with negative TTL error = %v, want *ConfigError", err)
+	}
+}
@@ -0,0 +1,179 @@
+package webdavfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// PartialUpdateDialect selects how the modified ranges of a file are written
+// back to the server. HTTP has no standard way to update part of a resource,
+// so each server that can do it has its own wire format.
+type PartialUpdateDialect int
+
+const (
+	// PartialUpdateAuto picks a dialect from the server's capabilities and
+	// uploads the whole file if the server advertises none (default)
+	PartialUpdateAuto PartialUpdateDialect = iota
+
+	// PartialUpdateNone always uploads the whole file
+	PartialUpdateNone
+
+	// PartialUpdateSabre sends PATCH requests with an X-Update-Range header,
+	// as supported by the sabre/dav partial update plugin
+	PartialUpdateSabre
+
+	// PartialUpdateApache sends PUT requests with a Content-Range header, as
+	// supported by Apache mod_dav_fs
+	PartialUpdateApache
+)
+
+// String returns the name of the dialect
+func (d PartialUpdateDialect) String() string {
+	switch d {
+	case PartialUpdateAuto:
+		return "auto"
+	case PartialUpdateNone:
+		return "none"
+	case PartialUpdateSabre:
+		return "sabre"
+	case PartialUpdateApache:
+		return "apache"
+	default:
+		return "PartialUpdateDialect(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+// maxPartialRanges is the number of modified ranges above which a file is
+// uploaded whole rather than with one request per range
+const maxPartialRanges = 16
+
+// Capability classes advertising the partial update dialects
+const (
+	sabrePartialUpdateClass = "sabredav-partialupdate"
+	apacheFSPropsetClass    = "<http://apache.org/dav/propset/fs/1>"
+)
+
+// errPartialRejected reports that the server did not accept a partial
+// update, so the file must be uploaded whole
+var errPartialRejected = errors.New("partial update rejected")
+
+// partialUpdates resolves and remembers the partial update dialect of the
+// server
+type partialUpdates struct {
+	mu      sync.Mutex
+	dialect PartialUpdateDialect
+}
+
+// partialDialect returns the dialect to use, asking the server for its
+// capabilities the first time if the dialect is detected automatically
+func (c *webdavClient) partialDialect(ctx context.Context) PartialUpdateDialect {
+	c.partial.mu.Lock()
+	dialect := c.partial.dialect
+	c.partial.mu.Unlock()
+	if dialect != PartialUpdateAuto {
+		return dialect
+	}
+
+	caps, err := c.capabilities(ctx)
+	switch {
+	case err != nil:
+		// Write in full this time, and ask again next time, since the
+		// failure may be transient
+		return PartialUpdateNone
+	case caps.HasClass(sabrePartialUpdateClass) && caps.Allows("PATCH"):
+		dialect = PartialUpdateSabre
+	case caps.HasClass(apacheFSPropsetClass):
+		dialect = PartialUpdateApache
+	default:
+		dialect = PartialUpdateNone
+	}
+	c.setPartialDialect(dialect)
+	return dialect
+}
+
+// setPartialDialect records the dialect to use from now on
+func (c *webdavClient) setPartialDialect(dialect PartialUpdateDialect) {
+	c.partial.mu.Lock()
+	c.partial.dialect = dialect
+	c.partial.mu.Unlock()
+}
+
+// patchRanges writes the given ranges of src to pathStr, whose size on the
+// server is remote, one request per range in ascending order. The first
+// request is conditioned on pre and each later one on the ETag returned by
+// the previous request, if any.
+//
+// It returns the ETag of the final version. If the server rejects the
+// dialect, the error is errPartialRejected and next is the precondition
+// for uploading the whole file instead, which accounts for the ranges
+// already written.
+func (c *webdavClient) patchRanges(ctx context.Context, pathStr string, dialect PartialUpdateDialect, src io.ReaderAt, ranges []byteRange, remote int64, pre precondition) (etag string, next precondition, err error) {
+	defer c.invalidate(pathStr)
+
+	next = pre
+	for i, r := range ranges {
+		etag, err = c.patchRange(ctx, pathStr, dialect, io.NewSectionReader(src, r.start, r.end-r.start), r.start, remote, next)
+		if err == errPartialRejected && i == 0 {
+			// The server cannot apply partial updates at all
+			c.setPartialDialect(PartialUpdateNone)
+		}
+		if err != nil {
+			return "", next, err
+		}
+		remote = max(remote, r.end)
+		next = ifMatch(etag)
+	}
+	return etag, next, nil
+}
+
+// patchRange writes body at offset start of pathStr, whose size on the server
+// is remote, and returns the new ETag if the server reports one
+func (c *webdavClient) patchRange(ctx context.Context, pathStr string, dialect PartialUpdateDialect, body *io.SectionReader, start, remote int64, pre precondition) (string, error) {
+	end := start + body.Size() - 1
+
+	method := "PUT"
+	headers := make(map[string]string)
+	switch dialect {
+	case PartialUpdateSabre:
+		method = "PATCH"
+		headers["Content-Type"] = "application/x-sabredav-partialupdate"
+		if start == remote {
+			headers["X-Update-Range"] = "append"
+		} else {
+			headers["X-Update-Range"] = fmt.Sprintf("bytes=%d-%d", start, end)
+		}
+	case PartialUpdateApache:
+		headers["Content-Type"] = "application/octet-stream"
+		headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/*", start, end)
+	default:
+		return "", errPartialRejected
+	}
+	pre.setHeaders(headers)
+	headers = c.setIfHeader(headers, pathStr)
+
+	resp, err := c.doRequest(ctx, method, pathStr, body, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return normalizeETag(resp.Header.Get("ETag")), nil
+	case http.StatusPreconditionFailed:
+		if pre.active() {
+			return "", pre.failed(pathStr)
+		}
+		return "", c.statusError(ctx, resp.StatusCode, pathStr)
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusUnsupportedMediaType,
+		http.StatusRequestedRangeNotSatisfiable, http.StatusNotImplemented:
+		return "", errPartialRejected
+	default:
+		return "", c.statusError(ctx, resp.StatusCode, pathStr)
+	}
+}
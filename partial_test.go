@@ -0,0 +1,289 @@
+package webdavfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// partialServer is a WebDAV server that applies partial updates in one of
+// the dialects and records the requests that change file content
+type partialServer struct {
+	*httptest.Server
+	fs webdav.FileSystem
+
+	mu       sync.Mutex
+	requests []string
+	reject   bool // Reject partial updates with 405
+	failOpts int  // OPTIONS requests still to fail with 503
+}
+
+func newPartialServer(t *testing.T, dialect PartialUpdateDialect) *partialServer {
+	t.Helper()
+	s := &partialServer{fs: webdav.NewMemFS()}
+	handler := &webdav.Handler{FileSystem: s.fs, LockSystem: webdav.NewMemLS()}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "OPTIONS":
+			s.mu.Lock()
+			fail := s.failOpts > 0
+			if fail {
+				s.failOpts--
+			}
+			s.mu.Unlock()
+			if fail {
+				http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			switch dialect {
+			case PartialUpdateSabre:
+				w.Header().Set("DAV", "1, 2, 3, sabredav-partialupdate")
+				w.Header().Set("Allow", "OPTIONS, GET, HEAD, DELETE, PROPFIND, PUT, PROPPATCH, COPY, MOVE, LOCK, UNLOCK, PATCH")
+			case PartialUpdateApache:
+				w.Header().Set("DAV", "1,2")
+				w.Header().Add("DAV", "<http://apache.org/dav/propset/fs/1>")
+			default:
+				handler.ServeHTTP(w, r)
+			}
+		case r.Method == "PATCH":
+			s.log("PATCH " + r.Header.Get("X-Update-Range"))
+			s.apply(w, r, r.Header.Get("X-Update-Range"))
+		case r.Method == "PUT" && r.Header.Get("Content-Range") != "":
+			s.log("PUT " + r.Header.Get("Content-Range"))
+			s.apply(w, r, r.Header.Get("Content-Range"))
+		default:
+			if r.Method == "PUT" || r.Method == "GET" {
+				s.log(r.Method)
+			}
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *partialServer) log(request string) {
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+}
+
+// takeRequests returns the recorded requests and starts a new record
+func (s *partialServer) takeRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+// apply writes the request body at the offset given by the range header
+func (s *partialServer) apply(w http.ResponseWriter, r *http.Request, rangeHeader string) {
+	s.mu.Lock()
+	reject := s.reject
+	s.mu.Unlock()
+	if reject {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := context.Background()
+	f, err := s.fs.OpenFile(ctx, r.URL.Path, os.O_RDWR, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	var start, end int64
+	whence := io.SeekStart
+	switch {
+	case rangeHeader == "append":
+		whence = io.SeekEnd
+	case strings.HasPrefix(rangeHeader, "bytes="):
+		fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+	default:
+		fmt.Sscanf(rangeHeader, "bytes %d-%d/*", &start, &end)
+	}
+	if _, err := f.Seek(start, whence); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if _, err := io.Copy(f, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAtAndClose modifies /file.txt with WriteAt and closes it
+func writeAtAndClose(t *testing.T, fs *FileSystem, writes map[int64]string) {
+	t.Helper()
+	f, err := fs.OpenFile("/file.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	for off, s := range writes {
+		if _, err := f.WriteAt([]byte(s), off); err != nil {
+			t.Fatalf("WriteAt() error = %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestPartialUpdate_Dialects(t *testing.T) {
+	original := strings.Repeat("abcdefghij", 100)
+	want := original[:100] + "XYZ" + original[103:] + "tail"
+
+	tests := []struct {
+		dialect  PartialUpdateDialect
+		requests []string
+	}{
+		{PartialUpdateSabre, []string{"PATCH bytes=100-102", "PATCH append"}},
+		{PartialUpdateApache, []string{"PUT bytes 100-102/*", "PUT bytes 1000-1003/*"}},
+		{PartialUpdateNone, []string{"GET", "PUT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect.String(), func(t *testing.T) {
+			server := newPartialServer(t, tt.dialect)
+			fs, err := New(&Config{URL: server.URL})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := fs.WriteFile("/file.txt", []byte(original), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			server.takeRequests()
+
+			f, err := fs.OpenFile("/file.txt", os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			f.WriteAt([]byte("XYZ"), 100)
+			f.WriteAt([]byte("tail"), 1000)
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if got := server.takeRequests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("requests = %q, want %q", got, tt.requests)
+			}
+			if got, err := fs.ReadFile("/file.txt"); err != nil || string(got) != want {
+				t.Errorf("ReadFile() = %d bytes, %v; want the patched content", len(got), err)
+			}
+		})
+	}
+}
+
+func TestPartialUpdate_Fallback(t *testing.T) {
+	original := strings.Repeat("0123456789", 10)
+
+	t.Run("rejected", func(t *testing.T) {
+		server := newPartialServer(t, PartialUpdateSabre)
+		server.reject = true
+		fs, err := New(&Config{URL: server.URL})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		fs.WriteFile("/file.txt", []byte(original), 0644)
+		server.takeRequests()
+
+		writeAtAndClose(t, fs, map[int64]string{10: "ab"})
+		if got, want := server.takeRequests(), []string{"PATCH bytes=10-11", "GET", "PUT"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requests = %q, want %q", got, want)
+		}
+
+		// The server's refusal is remembered
+		writeAtAndClose(t, fs, map[int64]string{20: "cd"})
+		if got, want := server.takeRequests(), []string{"GET", "PUT"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requests = %q, want %q", got, want)
+		}
+
+		want := original[:10] + "ab" + original[12:20] + "cd" + original[22:]
+		if got, _ := fs.ReadFile("/file.txt"); string(got) != want {
+			t.Errorf("ReadFile() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("discovery failed", func(t *testing.T) {
+		server := newPartialServer(t, PartialUpdateSabre)
+		server.failOpts = 1
+		fs, err := New(&Config{URL: server.URL})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		fs.WriteFile("/file.txt", []byte(original), 0644)
+		server.takeRequests()
+
+		writeAtAndClose(t, fs, map[int64]string{10: "ab"})
+		if got, want := server.takeRequests(), []string{"GET", "PUT"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requests = %q, want %q", got, want)
+		}
+
+		// A failed OPTIONS is not remembered
+		writeAtAndClose(t, fs, map[int64]string{20: "cd"})
+		if got, want := server.takeRequests(), []string{"PATCH bytes=20-21"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requests = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("shrunk", func(t *testing.T) {
+		server := newPartialServer(t, PartialUpdateSabre)
+		fs, err := New(&Config{URL: server.URL})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		fs.WriteFile("/file.txt", []byte(original), 0644)
+		server.takeRequests()
+
+		f, err := fs.OpenFile("/file.txt", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("OpenFile() error = %v", err)
+		}
+		f.WriteAt([]byte("ab"), 10)
+		f.Truncate(50)
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		if got, want := server.takeRequests(), []string{"GET", "PUT"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requests = %q, want %q", got, want)
+		}
+		if got, _ := fs.ReadFile("/file.txt"); string(got) != original[:10]+"ab"+original[12:50] {
+			t.Errorf("ReadFile() = %q", got)
+		}
+	})
+
+	t.Run("forced off", func(t *testing.T) {
+		server := newPartialServer(t, PartialUpdateSabre)
+		fs, err := New(&Config{URL: server.URL, PartialUpdate: PartialUpdateNone})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		fs.WriteFile("/file.txt", []byte(original), 0644)
+		server.takeRequests()
+
+		writeAtAndClose(t, fs, map[int64]string{10: "ab"})
+		if got, want := server.takeRequests(), []string{"GET", "PUT"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requests = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestConfig_PartialUpdateInvalid(t *testing.T) {
+	_, err := New(&Config{URL: "http://example.com", PartialUpdate: PartialUpdateDialect(42)})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("New() error = %v, want *ConfigError", err)
+	}
+}
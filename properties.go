@@ -2,7 +2,9 @@ package webdavfs
 
 import (
 	"encoding/xml"
+	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -15,38 +17,203 @@ const (
 	nsDAV = "DAV:"
 )
 
-// multistatus represents a WebDAV multistatus response
+// multistatus represents a WebDAV multistatus response (RFC 4918, section
+// 14.16). Elements are matched by namespace, so servers may use any prefix
+// for DAV: or declare it as the default namespace.
 type multistatus struct {
-	XMLName   xml.Name   `xml:"multistatus"`
-	Responses []response `xml:"response"`
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"DAV: response"`
 }
 
-// response represents a single response within a multistatus
+// response represents a single response within a multistatus. It holds
+// either the properties of one resource, grouped into a propstat per
+// status, or a status that applies to every listed href, as reported by
+// COPY, MOVE and DELETE and for members a PROPFIND cannot list.
 type response struct {
-	Href     string   `xml:"href"`
-	Propstat propstat `xml:"propstat"`
+	Hrefs       []string   `xml:"DAV: href"`
+	Status      string     `xml:"DAV: status"`
+	Propstats   []propstat `xml:"DAV: propstat"`
+	Description string     `xml:"DAV: responsedescription"`
+}
+
+// href returns the first href of the response, which is the only one
+// unless the response reports a status for several resources
+func (r *response) href() string {
+	if len(r.Hrefs) == 0 {
+		return ""
+	}
+	return r.Hrefs[0]
+}
+
+// statusCode returns the status of the response itself, or 0 if it reports
+// its properties in propstats instead
+func (r *response) statusCode() int {
+	code, _ := parseStatusLine(r.Status)
+	return code
+}
+
+// props returns the properties the server reported successfully. A
+// response usually has a 200 propstat with the properties found and a 404
+// propstat with empty elements for those the resource does not have; only
+// the extra properties of the latter are kept, so that requested
+// properties are listed in ResourceInfo.Properties even when missing.
+func (r *response) props() prop {
+	var p prop
+	for _, ps := range r.Propstats {
+		if ps.ok() {
+			p.merge(ps.Prop)
+		}
+	}
+	for _, ps := range r.Propstats {
+		if !ps.ok() {
+			p.Extra = append(p.Extra, ps.Prop.Extra...)
+		}
+	}
+	return p
 }
 
 // propstat represents property status
 type propstat struct {
-	Prop   prop   `xml:"prop"`
-	Status string `xml:"status"`
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+// ok reports whether the properties of the propstat were found. A missing
+// or malformed status is taken as success, as some servers omit it.
+func (ps *propstat) ok() bool {
+	code, valid := parseStatusLine(ps.Status)
+	return !valid || (code >= 200 && code < 300)
 }
 
 // prop represents WebDAV properties
 type prop struct {
-	DisplayName      string       `xml:"displayname"`
-	GetContentLength string       `xml:"getcontentlength"`
-	GetLastModified  string       `xml:"getlastmodified"`
-	ResourceType     resourceType `xml:"resourcetype"`
-	GetETag          string       `xml:"getetag"`
-	GetContentType   string       `xml:"getcontenttype"`
-	CreationDate     string       `xml:"creationdate"`
+	DisplayName      string       `xml:"DAV: displayname"`
+	GetContentLength string       `xml:"DAV: getcontentlength"`
+	GetLastModified  string       `xml:"DAV: getlastmodified"`
+	ResourceType     resourceType `xml:"DAV: resourcetype"`
+	GetETag          string       `xml:"DAV: getetag"`
+	GetContentType   string       `xml:"DAV: getcontenttype"`
+	CreationDate     string       `xml:"DAV: creationdate"`
+	LockDiscovery    []activeLock `xml:"DAV: lockdiscovery>activelock"`
+	SupportedLock    []lockEntry  `xml:"DAV: supportedlock>lockentry"`
+	Extra            []extraProp  `xml:",any"`
+}
+
+// merge adds the properties set in other to p
+func (p *prop) merge(other prop) {
+	for _, f := range []struct{ dst, src *string }{
+		{&p.DisplayName, &other.DisplayName},
+		{&p.GetContentLength, &other.GetContentLength},
+		{&p.GetLastModified, &other.GetLastModified},
+		{&p.GetETag, &other.GetETag},
+		{&p.GetContentType, &other.GetContentType},
+		{&p.CreationDate, &other.CreationDate},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if other.ResourceType.Collection != nil {
+		p.ResourceType = other.ResourceType
+	}
+	p.LockDiscovery = append(p.LockDiscovery, other.LockDiscovery...)
+	p.SupportedLock = append(p.SupportedLock, other.SupportedLock...)
+	p.Extra = append(p.Extra, other.Extra...)
+}
+
+// extraProp is a property not modeled by prop, such as those requested
+// through Config.ExtraProperties
+type extraProp struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// lockEntry is one entry of the DAV:supportedlock property
+type lockEntry struct {
+	LockScope lockScope `xml:"lockscope"`
+	LockType  lockType  `xml:"locktype"`
+}
+
+// lockScope is a DAV:lockscope element
+type lockScope struct {
+	Exclusive *struct{} `xml:"exclusive"`
+	Shared    *struct{} `xml:"shared"`
+}
+
+func (s lockScope) String() string {
+	switch {
+	case s.Exclusive != nil:
+		return "exclusive"
+	case s.Shared != nil:
+		return "shared"
+	default:
+		return ""
+	}
+}
+
+// lockType is a DAV:locktype element. Write is the only type RFC 4918
+// defines.
+type lockType struct {
+	Write *struct{} `xml:"write"`
+}
+
+func (t lockType) String() string {
+	if t.Write != nil {
+		return "write"
+	}
+	return ""
+}
+
+// ResourceInfo holds the WebDAV metadata of a resource as reported by the
+// server. It is returned by the Sys method of the os.FileInfo values
+// produced by this package, so no further request is needed to read it.
+type ResourceInfo struct {
+	// Href is the resource URL exactly as it appeared in the response
+	Href string
+
+	// ETag is the entity tag, including its quotes ("" if not reported)
+	ETag string
+
+	// ContentType is the MIME type reported by the server
+	ContentType string
+
+	// CreationTime is the creation date (zero if not reported)
+	CreationTime time.Time
+
+	// DisplayName is the server's display name for the resource
+	DisplayName string
+
+	// Locks lists the active locks on the resource (DAV:lockdiscovery)
+	Locks []ActiveLock
+
+	// SupportedLocks lists the kinds of locks the resource supports
+	// (DAV:supportedlock)
+	SupportedLocks []LockEntry
+
+	// Properties holds the text content of the properties requested through
+	// Config.ExtraProperties, keyed by namespace and local name
+	Properties map[xml.Name]string
+}
+
+// ActiveLock describes a lock held on a resource
+type ActiveLock struct {
+	Token     string
+	Owner     string
+	Root      string        // The locked resource, which may be an ancestor
+	Scope     string        // "exclusive" or "shared"
+	Recursive bool          // Whether the lock covers all members (Depth: infinity)
+	Timeout   time.Duration // Remaining time; negative if infinite, 0 if unknown
+}
+
+// LockEntry describes a kind of lock a resource supports
+type LockEntry struct {
+	Scope string // "exclusive" or "shared"
+	Type  string // "write"
 }
 
 // resourceType indicates if a resource is a collection (directory)
 type resourceType struct {
-	Collection *struct{} `xml:"collection"`
+	Collection *struct{} `xml:"DAV: collection"`
 }
 
 // fileInfo implements os.FileInfo for WebDAV resources
@@ -56,6 +223,7 @@ type fileInfo struct {
 	mode    os.FileMode
 	modTime time.Time
 	isDir   bool
+	res     *ResourceInfo
 }
 
 func (fi *fileInfo) Name() string       { return fi.name }
@@ -63,37 +231,111 @@ func (fi *fileInfo) Size() int64        { return fi.size }
 func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
 func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *fileInfo) IsDir() bool        { return fi.isDir }
-func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// Sys returns the *ResourceInfo of the resource, or nil if there is none
+func (fi *fileInfo) Sys() interface{} {
+	if fi.res == nil {
+		return nil
+	}
+	return fi.res
+}
 
 // parseMultistatus parses a WebDAV multistatus XML response
 func parseMultistatus(r io.Reader) (*multistatus, error) {
 	var ms multistatus
-	decoder := xml.NewDecoder(r)
-	if err := decoder.Decode(&ms); err != nil {
-		return nil, err
+	mr := newMultistatusReader(r)
+	for {
+		resp, err := mr.next()
+		if err == io.EOF {
+			return &ms, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ms.Responses = append(ms.Responses, resp)
 	}
-	return &ms, nil
 }
 
-// parseFileInfo converts a WebDAV response to os.FileInfo
+// multistatusReader decodes a multistatus body one response at a time, as
+// the body arrives, so that listings of any size are processed in bounded
+// memory
+type multistatusReader struct {
+	decoder *xml.Decoder
+	depth   int  // Nesting depth of the current element
+	root    bool // Whether the multistatus element has been seen
+}
+
+func newMultistatusReader(r io.Reader) *multistatusReader {
+	return &multistatusReader{decoder: xml.NewDecoder(r)}
+}
+
+// next returns the next response, or io.EOF after the last one
+func (mr *multistatusReader) next() (response, error) {
+	for {
+		tok, err := mr.decoder.Token()
+		if err == io.EOF {
+			if !mr.root || mr.depth > 0 {
+				return response{}, io.ErrUnexpectedEOF
+			}
+			return response{}, io.EOF
+		}
+		if err != nil {
+			return response{}, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if mr.depth == 0 {
+				if t.Name.Space != nsDAV || t.Name.Local != "multistatus" {
+					return response{}, fmt.Errorf("expected element <multistatus> in DAV:, got <%s> in %q", t.Name.Local, t.Name.Space)
+				}
+				mr.root = true
+				mr.depth++
+				continue
+			}
+			if mr.depth == 1 && t.Name.Space == nsDAV && t.Name.Local == "response" {
+				var resp response
+				if err := mr.decoder.DecodeElement(&resp, &t); err != nil {
+					return response{}, err
+				}
+				return resp, nil
+			}
+			// Skip anything else, such as a responsedescription
+			if err := mr.decoder.Skip(); err != nil {
+				return response{}, err
+			}
+		case xml.EndElement:
+			mr.depth--
+		}
+	}
+}
+
+// parseFileInfo converts a WebDAV response to the os.FileInfo
+// of the resource named by its href, or an error if the response reports
+// a failure status for the resource instead of its properties
 func parseFileInfo(resp response, basePath string) (os.FileInfo, error) {
+	if code := resp.statusCode(); code >= 300 {
+		return nil, httpStatusToOSError(code, basePath)
+	}
+
 	// Extract the name from href
 	// WebDAV hrefs are URL paths and should always use forward slashes
 	// However, normalize to handle any backslashes that might have slipped in
-	href := strings.ReplaceAll(resp.Href, "\\", "/")
-	href = strings.TrimPrefix(href, "/")
+	href := strings.ReplaceAll(hrefPath(resp.href()), "\\", "/")
 	name := path.Base(href)
-	if name == "" || name == "/" {
+	if name == "." || name == "/" {
 		// Normalize basePath too
 		basePath = strings.ReplaceAll(basePath, "\\", "/")
 		name = path.Base(basePath)
 	}
 
+	p := resp.props()
+
 	// Parse size
 	var size int64
-	if resp.Propstat.Prop.GetContentLength != "" {
+	if p.GetContentLength != "" {
 		var err error
-		size, err = strconv.ParseInt(resp.Propstat.Prop.GetContentLength, 10, 64)
+		size, err = strconv.ParseInt(p.GetContentLength, 10, 64)
 		if err != nil {
 			size = 0
 		}
@@ -101,14 +343,14 @@ func parseFileInfo(resp response, basePath string) (os.FileInfo, error) {
 
 	// Parse modification time
 	modTime := time.Now()
-	if resp.Propstat.Prop.GetLastModified != "" {
-		if t, err := parseWebDAVTime(resp.Propstat.Prop.GetLastModified); err == nil {
+	if p.GetLastModified != "" {
+		if t, err := parseWebDAVTime(p.GetLastModified); err == nil {
 			modTime = t
 		}
 	}
 
 	// Determine if it's a directory
-	isDir := resp.Propstat.Prop.ResourceType.Collection != nil
+	isDir := p.ResourceType.Collection != nil
 
 	// Set mode
 	mode := os.FileMode(0644)
@@ -122,9 +364,83 @@ func parseFileInfo(resp response, basePath string) (os.FileInfo, error) {
 		mode:    mode,
 		modTime: modTime,
 		isDir:   isDir,
+		res:     parseResourceInfo(resp.href(), p),
 	}, nil
 }
 
+// hrefPath returns the decoded path of an href, which may be an absolute
+// URL or an absolute path. Hrefs are percent-encoded, so "my%20file.txt"
+// names "my file.txt"; an href that cannot be decoded is used as is.
+func hrefPath(href string) string {
+	href = strings.TrimSpace(href)
+	if u, err := url.Parse(href); err == nil {
+		return u.Path
+	}
+	return href
+}
+
+// relativeHref returns the decoded path of href relative to base, or false
+// if href does not lie within base
+func relativeHref(base *url.URL, href string) (string, bool) {
+	basePath := strings.TrimSuffix(base.Path, "/")
+	p := strings.TrimSuffix(hrefPath(href), "/")
+	if p == basePath {
+		return "", true
+	}
+	if !strings.HasPrefix(p, basePath+"/") {
+		return "", false
+	}
+	return p[len(basePath)+1:], true
+}
+
+// parseResourceInfo collects the metadata of a response for FileInfo.Sys
+func parseResourceInfo(href string, p prop) *ResourceInfo {
+	res := &ResourceInfo{
+		Href:        href,
+		ETag:        normalizeETag(p.GetETag),
+		ContentType: p.GetContentType,
+		DisplayName: p.DisplayName,
+	}
+
+	if p.CreationDate != "" {
+		if t, err := parseWebDAVTime(p.CreationDate); err == nil {
+			res.CreationTime = t
+		}
+	}
+
+	for _, al := range p.LockDiscovery {
+		timeout, _ := parseLockTimeout(al.Timeout)
+		res.Locks = append(res.Locks, ActiveLock{
+			Token:     strings.TrimSpace(al.LockToken),
+			Owner:     al.Owner.text(),
+			Root:      strings.TrimSpace(al.LockRoot),
+			Scope:     al.LockScope.String(),
+			Recursive: strings.EqualFold(strings.TrimSpace(al.Depth), "infinity"),
+			Timeout:   timeout,
+		})
+	}
+
+	for _, le := range p.SupportedLock {
+		res.SupportedLocks = append(res.SupportedLocks, LockEntry{
+			Scope: le.LockScope.String(),
+			Type:  le.LockType.String(),
+		})
+	}
+
+	for _, e := range p.Extra {
+		if res.Properties == nil {
+			res.Properties = make(map[xml.Name]string)
+		}
+		// Keep a value over the empty element a 404 propstat lists
+		value := strings.TrimSpace(e.Value)
+		if _, seen := res.Properties[e.XMLName]; !seen || value != "" {
+			res.Properties[e.XMLName] = value
+		}
+	}
+
+	return res
+}
+
 // parseWebDAVTime parses various WebDAV time formats
 func parseWebDAVTime(s string) (time.Time, error) {
 	// Try RFC1123 format (HTTP-date)
@@ -158,9 +474,11 @@ func parseWebDAVTime(s string) (time.Time, error) {
 	}
 }
 
-// buildPropfindBody creates a PROPFIND request body
-func buildPropfindBody() string {
-	return `<?xml version="1.0" encoding="utf-8"?>
+// buildPropfindBody creates a PROPFIND request body, asking for the extra
+// properties in addition to the ones parseFileInfo uses
+func buildPropfindBody(extra ...xml.Name) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
 <D:propfind xmlns:D="DAV:">
   <D:prop>
     <D:displayname/>
@@ -170,8 +488,51 @@ func buildPropfindBody() string {
     <D:getetag/>
     <D:getcontenttype/>
     <D:creationdate/>
-  </D:prop>
-</D:propfind>`
+    <D:lockdiscovery/>
+    <D:supportedlock/>
+`)
+	for i, name := range extra {
+		b.WriteString("    " + propElement(name, i) + "\n")
+	}
+	b.WriteString(`  </D:prop>
+</D:propfind>`)
+	return b.String()
+}
+
+// propElement returns an empty element for the property name, declaring its
+// namespace under a prefix unique within the request
+func propElement(name xml.Name, i int) string {
+	return propValueElement(name, i, "")
+}
+
+// propValueElement returns an element for the property name with the text
+// value, which is empty if value is ""
+func propValueElement(name xml.Name, i int, value string) string {
+	var local strings.Builder
+	xml.EscapeText(&local, []byte(name.Local))
+
+	var open, tag string
+	switch name.Space {
+	case nsDAV:
+		tag = "D:" + local.String()
+		open = tag
+	case "":
+		tag = local.String()
+		open = tag + ` xmlns=""`
+	default:
+		var space strings.Builder
+		xml.EscapeText(&space, []byte(name.Space))
+		prefix := "x" + strconv.Itoa(i)
+		tag = prefix + ":" + local.String()
+		open = tag + " xmlns:" + prefix + `="` + space.String() + `"`
+	}
+
+	if value == "" {
+		return "<" + open + "/>"
+	}
+	var text strings.Builder
+	xml.EscapeText(&text, []byte(value))
+	return "<" + open + ">" + text.String() + "</" + tag + ">"
 }
 
 // buildProppatchBody creates a PROPPATCH request body for setting modification time
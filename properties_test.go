@@ -0,0 +1,205 @@
+package webdavfs
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseTestMultistatus(t *testing.T, body string) *multistatus {
+	t.Helper()
+	ms, err := parseMultistatus(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("parseMultistatus() error = %v", err)
+	}
+	return ms
+}
+
+func TestParseFileInfo_MultiplePropstats(t *testing.T) {
+	// The 404 propstat lists empty elements for the missing properties; it
+	// comes first here to show that order does not matter
+	ms := parseTestMultistatus(t, `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:response>
+    <d:href>/docs/report.pdf</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getcontentlength/>
+        <d:creationdate/>
+        <oc:fileid/>
+      </d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop>
+        <d:getcontentlength>2048</d:getcontentlength>
+        <d:getlastmodified>Tue, 02 Jan 2024 10:00:00 GMT</d:getlastmodified>
+        <d:resourcetype/>
+        <oc:size>2048</oc:size>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`)
+
+	info, err := parseFileInfo(ms.Responses[0], "/docs/report.pdf")
+	if err != nil {
+		t.Fatalf("parseFileInfo() error = %v", err)
+	}
+	if info.Size() != 2048 {
+		t.Errorf("Size() = %d, want 2048", info.Size())
+	}
+	if want := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("ModTime() = %v, want %v", info.ModTime(), want)
+	}
+
+	res := info.Sys().(*ResourceInfo)
+	if !res.CreationTime.IsZero() {
+		t.Errorf("CreationTime = %v, want zero", res.CreationTime)
+	}
+	want := map[xml.Name]string{
+		{Space: "http://owncloud.org/ns", Local: "fileid"}: "",
+		{Space: "http://owncloud.org/ns", Local: "size"}:   "2048",
+	}
+	if !reflect.DeepEqual(res.Properties, want) {
+		t.Errorf("Properties = %v, want %v", res.Properties, want)
+	}
+}
+
+func TestParseFileInfo_Href(t *testing.T) {
+	tests := []struct {
+		href string
+		name string
+	}{
+		{"/dav/my%20file.txt", "my file.txt"},
+		{"/dav/100%25%20done.txt", "100% done.txt"},
+		{"/dav/%E6%96%87%E4%BB%B6/", "文件"},
+		{"https://cloud.example.com/remote.php/dav/files/u/a%2Bb.txt", "a+b.txt"},
+		{"  /dav/spaced.txt\n", "spaced.txt"},
+		{"/dav/bad%zz", "bad%zz"},
+		{"/", "base"},
+	}
+	for _, tt := range tests {
+		resp := response{Hrefs: []string{tt.href}}
+		info, err := parseFileInfo(resp, "/dir/base")
+		if err != nil {
+			t.Errorf("parseFileInfo(%q) error = %v", tt.href, err)
+			continue
+		}
+		if info.Name() != tt.name {
+			t.Errorf("parseFileInfo(%q).Name() = %q, want %q", tt.href, info.Name(), tt.name)
+		}
+		if got := info.Sys().(*ResourceInfo).Href; got != tt.href {
+			t.Errorf("Href = %q, want the href as sent %q", got, tt.href)
+		}
+	}
+}
+
+func TestParseFileInfo_ResponseStatus(t *testing.T) {
+	resp := response{Hrefs: []string{"/private/"}, Status: "HTTP/1.1 403 Forbidden"}
+	if _, err := parseFileInfo(resp, "/private"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("parseFileInfo() error = %v, want ErrPermission", err)
+	}
+}
+
+func TestFileSystem_ReaddirMultistatus(t *testing.T) {
+	// A listing with absolute URL hrefs, a default DAV: namespace, the
+	// directory itself reported last and a member the user cannot read
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := "http://" + r.Host
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<multistatus xmlns="DAV:" xmlns:lp1="DAV:">
+  <response>
+    <href>` + host + `/dav/docs/my%20notes.txt</href>
+    <propstat>
+      <prop><lp1:getcontentlength>12</lp1:getcontentlength><resourcetype/></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+    <propstat>
+      <prop><lp1:getcontentlength/><getetag/></prop>
+      <status>HTTP/1.1 404 Not Found</status>
+    </propstat>
+  </response>
+  <response>
+    <href>/dav/docs/private/</href>
+    <status>HTTP/1.1 403 Forbidden</status>
+  </response>
+  <response>
+    <href>/dav/docs/sub%23dir/</href>
+    <propstat>
+      <prop><resourcetype><collection/></resourcetype></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+  <response>
+    <href>` + host + `/dav/docs/</href>
+    <propstat>
+      <prop><resourcetype><collection/></resourcetype></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`))
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL + "/dav/"})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	entries, err := fs.ReadDir("/docs")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	got := make(map[string]int64)
+	var names []string
+	for _, e := range entries {
+		info, _ := e.Info()
+		got[e.Name()] = info.Size()
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"my notes.txt", "sub#dir"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir() names = %q, want %q", names, want)
+	}
+	if got["my notes.txt"] != 12 {
+		t.Errorf("size of my notes.txt = %d, want 12", got["my notes.txt"])
+	}
+}
+
+func TestParseMultiStatusError_SharedStatus(t *testing.T) {
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(`<?xml version="1.0"?>
+<D:multistatus xmlns:D="DAV:">
+  <D:response>
+    <D:href>/dir/a</D:href>
+    <D:href>/dir/b</D:href>
+    <D:status>HTTP/1.1 423 Locked</D:status>
+  </D:response>
+  <D:response>
+    <D:href>/dir/c</D:href>
+    <D:status>HTTP/1.1 204 No Content</D:status>
+  </D:response>
+</D:multistatus>`))}
+
+	err := parseMultiStatusError(resp, "DELETE", "/dir")
+	var mse *MultiStatusError
+	if !errors.As(err, &mse) {
+		t.Fatalf("parseMultiStatusError() = %v, want *MultiStatusError", err)
+	}
+	var hrefs []string
+	for _, re := range mse.Errors {
+		hrefs = append(hrefs, re.Href)
+	}
+	if want := []string{"/dir/a", "/dir/b"}; !reflect.DeepEqual(hrefs, want) {
+		t.Errorf("failed hrefs = %q, want %q", hrefs, want)
+	}
+}
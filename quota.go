@@ -0,0 +1,127 @@
+package webdavfs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// QuotaUnknown is reported for quota values the server does not provide,
+// including an available space that is unlimited
+const QuotaUnknown = -1
+
+// Quota property names (RFC 4331)
+var (
+	quotaAvailableProp = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	quotaUsedProp      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+)
+
+// QuotaInfo reports the storage quota of a collection (RFC 4331)
+type QuotaInfo struct {
+	// Available is the number of bytes that can still be stored, or
+	// QuotaUnknown if the server does not say or the space is unlimited
+	Available int64
+
+	// Used is the number of bytes stored, or QuotaUnknown if the server
+	// does not say
+	Used int64
+}
+
+// Quota returns the storage quota that applies to name, usually a
+// directory. It fails with errors.ErrUnsupported if the server reports
+// neither quota property.
+func (fs *FileSystem) Quota(name string) (QuotaInfo, error) {
+	return fs.client.quota(fs.context(), fs.cleanPath(name))
+}
+
+// quota queries the quota properties of pathStr
+func (c *webdavClient) quota(ctx context.Context, pathStr string) (QuotaInfo, error) {
+	props, err := c.getProperties(ctx, pathStr, buildPropBody([]xml.Name{quotaAvailableProp, quotaUsedProp}))
+	if err != nil {
+		return QuotaInfo{}, err
+	}
+
+	info := QuotaInfo{
+		Available: quotaValue(props[quotaAvailableProp]),
+		Used:      quotaValue(props[quotaUsedProp]),
+	}
+	if info.Available == QuotaUnknown && info.Used == QuotaUnknown {
+		return QuotaInfo{}, &os.PathError{Op: "quota", Path: pathStr, Err: errors.ErrUnsupported}
+	}
+	return info, nil
+}
+
+// quotaValue parses a quota property. Servers such as Nextcloud report
+// unknown or unlimited quotas as negative numbers.
+func quotaValue(p Property) int64 {
+	if p.Status != 0 && p.Status != 200 {
+		return QuotaUnknown
+	}
+	n, err := strconv.ParseInt(p.Value, 10, 64)
+	if err != nil || n < 0 {
+		return QuotaUnknown
+	}
+	return n
+}
+
+// checkQuota fails with ErrInsufficientStorage if storing size bytes at
+// pathStr would grow it by more than the space available in its directory.
+// Quota checks are best effort: if the quota cannot be determined, the
+// upload goes ahead and the server has the final word.
+func (c *webdavClient) checkQuota(ctx context.Context, pathStr string, size int64) error {
+	if !c.quotaCheck || size <= 0 {
+		return nil
+	}
+	info, err := c.quota(ctx, path.Dir(pathStr))
+	if err != nil || info.Available == QuotaUnknown {
+		return nil
+	}
+
+	// Replacing a file frees the space it took up
+	grow := size
+	if existing, err := c.stat(ctx, pathStr); err == nil && !existing.IsDir() {
+		grow -= existing.Size()
+	}
+	if grow <= info.Available {
+		return nil
+	}
+	return &os.PathError{Op: "write", Path: pathStr, Err: ErrInsufficientStorage}
+}
+
+// declaredSize returns the number of bytes left in r if r can tell without
+// being read, or -1
+func declaredSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return max(info.Size()-pos, 0)
+	case io.Seeker:
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return -1
+		}
+		return max(end-pos, 0)
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,183 @@
+package webdavfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// quotaServer is a WebDAV server reporting a fixed quota and counting PUT
+// requests
+type quotaServer struct {
+	*httptest.Server
+	available string
+
+	mu   sync.Mutex
+	puts int
+}
+
+func newQuotaServer(t *testing.T, available string) *quotaServer {
+	t.Helper()
+	s := &quotaServer{available: available}
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" {
+			body, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(body), "quota-available-bytes") {
+				w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+				w.WriteHeader(http.StatusMultiStatus)
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>%s</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:quota-available-bytes>%s</d:quota-available-bytes>
+        <d:quota-used-bytes>1234</d:quota-used-bytes>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`, r.URL.Path, s.available)
+				return
+			}
+			r.Body = io.NopCloser(strings.NewReader(string(body)))
+		}
+		if r.Method == "PUT" {
+			s.mu.Lock()
+			s.puts++
+			s.mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *quotaServer) putCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.puts
+}
+
+func TestFileSystem_Quota(t *testing.T) {
+	server := newQuotaServer(t, "5000")
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	quota, err := fs.Quota("/")
+	if err != nil {
+		t.Fatalf("Quota() error = %v", err)
+	}
+	if quota.Available != 5000 || quota.Used != 1234 {
+		t.Errorf("Quota() = %+v, want Available 5000, Used 1234", quota)
+	}
+
+	// Nextcloud reports an unlimited quota as -3
+	unlimited := newQuotaServer(t, "-3")
+	fs, err = New(&Config{URL: unlimited.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if quota, err := fs.Quota("/"); err != nil || quota.Available != QuotaUnknown {
+		t.Errorf("Quota() = %+v, %v; want Available QuotaUnknown", quota, err)
+	}
+
+	// Without quota properties
+	server2 := newLockingServer(t)
+	fs, err = New(&Config{URL: server2.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if _, err := fs.Quota("/"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Quota() error = %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestInsufficientStorage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PROPFIND":
+			http.Error(w, "Not Found", http.StatusNotFound)
+		default:
+			http.Error(w, "Insufficient Storage", http.StatusInsufficientStorage)
+		}
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.WriteFile("/file.txt", []byte("data"), 0644); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("WriteFile() error = %v, want ErrInsufficientStorage", err)
+	}
+}
+
+func TestConfig_QuotaCheck(t *testing.T) {
+	server := newQuotaServer(t, "100")
+	fs, err := New(&Config{URL: server.URL, QuotaCheck: true})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := fs.WriteFile("/file.txt", make([]byte, 80), 0644); err != nil {
+		t.Fatalf("WriteFile() within quota error = %v", err)
+	}
+	puts := server.putCount()
+
+	// Too large: refused without sending the data
+	if err := fs.WriteFile("/big.txt", make([]byte, 200), 0644); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("WriteFile() over quota error = %v, want ErrInsufficientStorage", err)
+	}
+	if n := server.putCount(); n != puts {
+		t.Errorf("server received %d PUTs for a refused upload", n-puts)
+	}
+
+	// Replacing a file only needs room for the growth
+	if err := fs.WriteFile("/file.txt", make([]byte, 150), 0644); err != nil {
+		t.Errorf("WriteFile() replacing a file error = %v", err)
+	}
+
+	// Copying from a local file declares its size
+	local := filepath.Join(t.TempDir(), "local.bin")
+	if err := os.WriteFile(local, make([]byte, 500), 0600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := fs.OpenFile("/copy.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if _, err := io.Copy(dst, src); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("io.Copy() over quota error = %v, want ErrInsufficientStorage", err)
+	}
+	dst.Close()
+
+	// Modified files are checked when they are written back
+	f, err := fs.OpenFile("/file.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 101), 150); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	if err := f.Close(); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("Close() over quota error = %v, want ErrInsufficientStorage", err)
+	}
+}
@@ -0,0 +1,151 @@
+package webdavfs
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// noFollow stops http.Client from following redirects itself. It would
+// turn a redirected PROPFIND, MKCOL or MOVE into a GET and drop the body,
+// so redirects are followed by sendFollowingRedirects instead.
+func noFollow(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// sendFollowingRedirects sends req with credentials and follows 301, 302,
+// 307 and 308 redirects, up to the configured number of hops. Every hop
+// repeats the method, body and headers of req. Credentials are only sent
+// to the origin (scheme and host) of req, so a redirect to another host
+// never sees them. 303 See Other, and redirects of requests whose body
+// cannot be replayed, are returned to the caller as is.
+func (c *webdavClient) sendFollowingRedirects(req *http.Request) (*http.Response, error) {
+	origin := req.URL
+	header := req.Header.Clone() // Before credentials are added
+	for hops := 0; ; hops++ {
+		var resp *http.Response
+		var err error
+		if sameOrigin(req.URL, origin) {
+			resp, err = c.sendAuthorized(req)
+		} else {
+			resp, err = c.send(req)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		next := c.redirectRequest(req, resp, header)
+		if next == nil {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if hops >= c.maxRedirects {
+			return nil, ErrTooManyRedirects
+		}
+		if !sameOrigin(next.URL, origin) {
+			next.Header.Del("Authorization")
+		}
+		c.slashes.learn(req.URL, next.URL)
+		req = next
+	}
+}
+
+// redirectRequest returns the request that follows the redirect in resp,
+// or nil if resp is not a redirect to follow
+func (c *webdavClient) redirectRequest(req *http.Request, resp *http.Response, header http.Header) *http.Request {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil
+	}
+	if c.maxRedirects < 0 {
+		return nil
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil
+	}
+	target, err := req.URL.Parse(loc)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil
+	}
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !replayable {
+		return nil
+	}
+
+	next, err := http.NewRequestWithContext(req.Context(), req.Method, target.String(), nil)
+	if err != nil {
+		return nil
+	}
+	next.Header = header.Clone()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil
+		}
+		next.Body = body
+		next.GetBody = req.GetBody
+		next.ContentLength = req.ContentLength
+	}
+	return next
+}
+
+// sameOrigin reports whether a and b have the same scheme and host
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// trailingSlashes remembers the collections the server redirected to their
+// canonical form with a trailing slash, so that later requests for them
+// are sent there directly. It is keyed by URL path without the slash.
+type trailingSlashes struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// learn records from.Path if to is the same URL with a trailing slash
+func (ts *trailingSlashes) learn(from, to *url.URL) {
+	if !sameOrigin(from, to) || to.Path != from.Path+"/" || strings.HasSuffix(from.Path, "/") {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.paths == nil {
+		ts.paths = make(map[string]bool)
+	}
+	ts.paths[from.Path] = true
+}
+
+// canonical returns urlPath with a trailing slash if the server asked for
+// one
+func (ts *trailingSlashes) canonical(urlPath string) string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.paths[urlPath] {
+		return urlPath + "/"
+	}
+	return urlPath
+}
+
+// forget drops what is known about urlPath and the paths below it, which
+// may no longer be collections after they were modified
+func (ts *trailingSlashes) forget(urlPath string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for p := range ts.paths {
+		if p == urlPath || isAncestor(urlPath, p) {
+			delete(ts.paths, p)
+		}
+	}
+}
+
+// urlPath returns the URL path of pathStr below the base URL, without a
+// trailing slash
+func (c *webdavClient) urlPath(pathStr string) string {
+	return path.Join(c.baseURL.Path, path.Clean("/"+strings.TrimPrefix(pathStr, "/")))
+}
@@ -0,0 +1,284 @@
+package webdavfs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// slashServer is a WebDAV server that, like Apache and IIS, redirects
+// requests for collections without a trailing slash
+type slashServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	redirects []string
+}
+
+func newSlashServer(t *testing.T) *slashServer {
+	t.Helper()
+	s := &slashServer{}
+	mem := webdav.NewMemFS()
+	handler := &webdav.Handler{FileSystem: mem, LockSystem: webdav.NewMemLS()}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "MKCOL" && !strings.HasSuffix(r.URL.Path, "/") {
+			if info, err := mem.Stat(r.Context(), r.URL.Path); err == nil && info.IsDir() {
+				s.mu.Lock()
+				s.redirects = append(s.redirects, r.Method+" "+r.URL.Path)
+				s.mu.Unlock()
+				http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *slashServer) takeRedirects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.redirects
+	s.redirects = nil
+	return r
+}
+
+func TestRedirect_TrailingSlash(t *testing.T) {
+	server := newSlashServer(t)
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := fs.WriteFile("/dir/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// A PROPFIND redirected with 301 stays a PROPFIND
+	info, err := fs.Stat("/dir")
+	if err != nil || !info.IsDir() {
+		t.Fatalf("Stat() = %v, %v; want a directory", info, err)
+	}
+	if got := server.takeRedirects(); len(got) != 1 || got[0] != "PROPFIND /dir" {
+		t.Errorf("redirects = %q, want one PROPFIND", got)
+	}
+
+	// The canonical form is remembered
+	entries, err := fs.ReadDir("/dir")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadDir() = %d entries, %v; want 1", len(entries), err)
+	}
+	if got := server.takeRedirects(); len(got) != 0 {
+		t.Errorf("redirects after the first = %q, want none", got)
+	}
+
+	// and forgotten once the path may no longer be a collection
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := fs.WriteFile("/dir", []byte("now a file"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if data, err := fs.ReadFile("/dir"); err != nil || string(data) != "now a file" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+}
+
+// redirectRecord is a request as received by the redirect target
+type redirectRecord struct {
+	method, path, body                     string
+	depth, destination, overwrite, ifLocks string
+	authorization                          string
+}
+
+// newRedirectTarget is a server recording requests, answering PUT with 201
+// and everything else with 204
+func newRedirectTarget(t *testing.T) (*httptest.Server, func() []redirectRecord) {
+	t.Helper()
+	var mu sync.Mutex
+	var records []redirectRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		records = append(records, redirectRecord{
+			method:        r.Method,
+			path:          r.URL.Path,
+			body:          string(body),
+			depth:         r.Header.Get("Depth"),
+			destination:   r.Header.Get("Destination"),
+			overwrite:     r.Header.Get("Overwrite"),
+			ifLocks:       r.Header.Get("If"),
+			authorization: r.Header.Get("Authorization"),
+		})
+		mu.Unlock()
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []redirectRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		r := records
+		records = nil
+		return r
+	}
+}
+
+func TestRedirect_PreservesRequest(t *testing.T) {
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		target, records := newRedirectTarget(t)
+		var authorized []string
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorized = append(authorized, r.Header.Get("Authorization"))
+			http.Redirect(w, r, target.URL+"/moved"+r.URL.Path, code)
+		}))
+		defer origin.Close()
+
+		fs, err := New(&Config{URL: origin.URL, Username: "user", Password: "secret"})
+		if err != nil {
+			t.Fatalf("Failed to create filesystem: %v", err)
+		}
+		c := fs.client
+		ctx := fs.context()
+
+		// A seekable body, as for the write-back of a modified file
+		if _, err := c.putCond(ctx, "/f.txt", strings.NewReader("content"), precondition{}); err != nil {
+			t.Errorf("%d: PUT error = %v", code, err)
+		}
+		if err := c.move(ctx, "/f.txt", "/g.txt", false); err != nil {
+			t.Errorf("%d: MOVE error = %v", code, err)
+		}
+
+		got := records()
+		if len(got) != 2 {
+			t.Fatalf("%d: target received %d requests, want 2", code, len(got))
+		}
+		if put := got[0]; put.method != "PUT" || put.path != "/moved/f.txt" || put.body != "content" {
+			t.Errorf("%d: PUT arrived as %s %s with body %q", code, put.method, put.path, put.body)
+		}
+		move := got[1]
+		if move.method != "MOVE" || !strings.HasSuffix(move.destination, "/g.txt") || move.overwrite != "F" {
+			t.Errorf("%d: MOVE arrived as %s with Destination %q, Overwrite %q", code, move.method, move.destination, move.overwrite)
+		}
+		for _, r := range got {
+			if r.authorization != "" {
+				t.Errorf("%d: credentials sent to another host with %s: %q", code, r.method, r.authorization)
+			}
+		}
+		for _, a := range authorized {
+			if !strings.HasPrefix(a, "Basic ") {
+				t.Errorf("%d: origin received Authorization %q, want Basic credentials", code, a)
+			}
+		}
+	}
+}
+
+func TestRedirect_SameHostKeepsCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.Path+" "+r.Header.Get("Depth")+" "+r.Header.Get("Authorization"))
+		mu.Unlock()
+		if !strings.HasPrefix(r.URL.Path, "/new/") {
+			http.Redirect(w, r, "/new"+r.URL.Path, http.StatusPermanentRedirect)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<D:multistatus xmlns:D="DAV:"><D:response><D:href>/new/docs/</D:href>
+<D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response></D:multistatus>`))
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL, BearerToken: "tok"})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if _, err := fs.Stat("/docs"); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	want := []string{"/docs 0 Bearer tok", "/new/docs 0 Bearer tok"}
+	if strings.Join(seen, "|") != strings.Join(want, "|") {
+		t.Errorf("requests = %q, want %q", seen, want)
+	}
+}
+
+func TestRedirect_Limit(t *testing.T) {
+	var hops int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL, MaxRedirects: 3})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if _, err := fs.Stat("/loop"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Stat() error = %v, want ErrTooManyRedirects", err)
+	}
+	if hops != 4 {
+		t.Errorf("server saw %d requests, want 4 (3 redirects)", hops)
+	}
+
+	hops = 0
+	fs, err = New(&Config{URL: server.URL, MaxRedirects: -1})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	_, err = fs.Stat("/loop")
+	var de *WebDAVError
+	if !errors.As(err, &de) || de.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("Stat() without following redirects error = %v, want the 307 response", err)
+	}
+	if hops != 1 {
+		t.Errorf("server saw %d requests, want 1", hops)
+	}
+}
+
+func TestRedirect_CustomClientNotModified(t *testing.T) {
+	custom := &http.Client{}
+	if _, err := New(&Config{URL: "http://example.com", HTTPClient: custom}); err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if custom.CheckRedirect != nil {
+		t.Error("New() changed the CheckRedirect of the caller's client")
+	}
+}
+
+func TestRedirect_StreamedUploadFails(t *testing.T) {
+	target, records := newRedirectTarget(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Redirect(w, r, target.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer origin.Close()
+
+	fs, err := New(&Config{URL: origin.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	// The body of a streamed upload cannot be sent again
+	if err := fs.WriteFile("/f.txt", []byte("content"), 0644); err == nil {
+		t.Error("WriteFile() succeeded without reaching the redirect target")
+	}
+	for _, r := range records() {
+		if r.method == "PUT" {
+			t.Errorf("target received a PUT with body %q", r.body)
+		}
+	}
+}
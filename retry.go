@@ -0,0 +1,223 @@
+package webdavfs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy configures how the client retries requests that fail with
+// transient errors such as dropped connections or 502/503/429 responses.
+//
+// Only idempotent methods (GET, HEAD, OPTIONS, PROPFIND, PROPPATCH, PUT,
+// DELETE and UNLOCK) are retried by default. Requests whose body cannot be
+// replayed are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, including the
+	// first one (default: 3)
+	MaxAttempts int
+
+	// InitialBackoff is the base delay before the first retry (default: 200ms).
+	// Each further retry doubles it, and the actual delay is chosen at random
+	// between zero and that value.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts (default: 10 seconds).
+	// A Retry-After header asking for a longer delay ends the retries and the
+	// response is returned to the caller as is.
+	MaxBackoff time.Duration
+
+	// RetryableStatus lists the HTTP status codes that are retried
+	// (default: 429, 502, 503 and 504)
+	RetryableStatus []int
+
+	// RetryNonIdempotent allows methods that are not idempotent, such as MOVE,
+	// COPY, MKCOL and LOCK, to be retried. A retried MOVE may report an error
+	// even though the first attempt succeeded, so enable this only when the
+	// caller can cope with that.
+	RetryNonIdempotent bool
+}
+
+// setDefaults fills in zero values of the policy
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = 200 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	if p.RetryableStatus == nil {
+		p.RetryableStatus = []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}
+	}
+}
+
+// validate checks the policy for invalid values
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 {
+		return &ConfigError{Field: "Retry.MaxAttempts", Reason: "must not be negative"}
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return &ConfigError{Field: "Retry", Reason: "backoff durations must not be negative"}
+	}
+	return nil
+}
+
+// isIdempotentMethod reports whether repeating a request with the given method
+// has the same effect on the server as sending it once
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND", "PROPPATCH", "PUT", "DELETE", "UNLOCK":
+		return true
+	default:
+		return false
+	}
+}
+
+// canRetry reports whether req may be sent more than once under the policy
+func (p *RetryPolicy) canRetry(req *http.Request) bool {
+	if p.MaxAttempts <= 1 {
+		return false
+	}
+	if !p.RetryNonIdempotent && !isIdempotentMethod(req.Method) {
+		return false
+	}
+	// The body must be replayable
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryableStatus reports whether code is one of the policy's retryable codes
+func (p *RetryPolicy) retryableStatus(code int) bool {
+	for _, c := range p.RetryableStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry (1 for the first retry),
+// using exponential backoff with full jitter
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// parseRetryAfter parses a Retry-After header value given either as a number
+// of seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// isPermanentTransportError reports whether err is a transport failure that
+// will not go away by trying again, such as a rejected server certificate
+func isPermanentTransportError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.Is(err, ErrPinMismatch) ||
+		errors.As(err, &certErr) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
+
+// send sends req, retrying transient failures according to the client's
+// retry policy. The final response or error is returned unchanged, so callers
+// map status codes exactly as they would for a single attempt.
+func (c *webdavClient) send(req *http.Request) (*http.Response, error) {
+	policy := c.retry
+	if policy == nil || !policy.canRetry(req) {
+		return c.httpClient.Do(req)
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := c.httpClient.Do(r)
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+
+		delay := policy.backoff(attempt)
+		if err != nil {
+			if isPermanentTransportError(err) {
+				return nil, err
+			}
+		} else {
+			if !policy.retryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if d > policy.MaxBackoff {
+					return resp, nil
+				}
+				if d > delay {
+					delay = d
+				}
+			}
+			// Drain so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package webdavfs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetry_TransientStatus(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+
+		if n < 3 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		handlePropfind(w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{
+		URL:   server.URL,
+		Retry: &RetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	info, err := fs.Stat("/test.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size() != 11 {
+		t.Errorf("Expected size 11, got %d", info.Size())
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestRetry_GivesUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{
+		URL:   server.URL,
+		Retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := fs.Mkdir("/dir", 0755); err == nil {
+		t.Error("Mkdir() expected error")
+	}
+	// MKCOL is not idempotent, so it is attempted once
+	if attempts != 1 {
+		t.Errorf("MKCOL: expected 1 attempt, got %d", attempts)
+	}
+
+	attempts = 0
+	if _, err := fs.Stat("/test.txt"); err == nil {
+		t.Error("Stat() expected error")
+	}
+	if attempts != 2 {
+		t.Errorf("PROPFIND: expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestRetry_ReplaysPutBody(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		if len(bodies) == 1 {
+			http.Error(w, "try again", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{
+		URL:   server.URL,
+		Retry: &RetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	if err := fs.client.put(fs.context(), "/file.txt", strings.NewReader("payload")); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Errorf("Expected body replayed on retry, got %q", bodies)
+	}
+}
+
+func TestRetry_ReplaysSeekableBody(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		if len(bodies) < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{
+		URL:   server.URL,
+		Retry: &RetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// A section that has already been partly read replays from where the
+	// first attempt started
+	body := io.NewSectionReader(strings.NewReader("--payload--"), 2, 9)
+	body.Seek(2, io.SeekStart)
+	if err := fs.client.put(fs.context(), "/file.txt", body); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	want := []string{"yload--", "yload--", "yload--"}
+	if strings.Join(bodies, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected body %q replayed on each attempt, got %q", want[0], bodies)
+	}
+}
+
+func TestRetry_MoveRequiresOptIn(t *testing.T) {
+	for _, optIn := range []bool{false, true} {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		fs, err := New(&Config{
+			URL: server.URL,
+			Retry: &RetryPolicy{
+				InitialBackoff:     time.Millisecond,
+				RetryNonIdempotent: optIn,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create filesystem: %v", err)
+		}
+
+		err = fs.client.move(fs.context(), "/a.txt", "/b.txt", false)
+		server.Close()
+
+		if optIn {
+			if err != nil || attempts != 2 {
+				t.Errorf("opt-in: expected success after 2 attempts, got err=%v attempts=%d", err, attempts)
+			}
+		} else if err == nil || attempts != 1 {
+			t.Errorf("default: expected failure after 1 attempt, got err=%v attempts=%d", err, attempts)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2024 00:00:30 GMT", 30 * time.Second, true},
+		{"Sun, 31 Dec 2023 23:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package webdavfs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig configures TLS for the default HTTP client. It is mutually
+// exclusive with Config.HTTPClient, whose transport carries its own TLS
+// settings.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of the certificate authorities trusted to sign
+	// server certificates (optional). CAFile and CAPEM are combined; if both
+	// are empty, the system roots are trusted.
+	CAFile string
+
+	// CAPEM holds PEM encoded CA certificates, like the content of CAFile
+	CAPEM []byte
+
+	// CertFile and KeyFile are the PEM encoded client certificate and its
+	// private key, presented to servers that require mutual TLS (optional)
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the host name the server certificate is verified
+	// against (optional). It is also sent in SNI.
+	ServerName string
+
+	// MinVersion is the minimum TLS version accepted, e.g. tls.VersionTLS13
+	// (default: tls.VersionTLS12)
+	MinVersion uint16
+
+	// PinnedPublicKeys restricts the servers accepted to those whose verified
+	// certificate chain contains one of these public keys (optional). Each
+	// pin is the base64 encoded SHA-256 hash of a DER SubjectPublicKeyInfo,
+	// optionally prefixed with "sha256/", as produced by:
+	//
+	//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+	//
+	// Pins are checked in addition to the usual certificate verification.
+	// A mismatch fails the request with ErrPinMismatch.
+	PinnedPublicKeys []string
+}
+
+// build loads the certificates and returns the tls.Config. Bad material is
+// reported as a *ConfigError.
+func (tc *TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: tc.ServerName,
+		MinVersion: tc.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.MinVersion < tls.VersionTLS10 || config.MinVersion > tls.VersionTLS13 {
+		return nil, &ConfigError{Field: "TLS.MinVersion", Reason: fmt.Sprintf("unknown TLS version %#04x", config.MinVersion)}
+	}
+
+	if tc.CAFile != "" || len(tc.CAPEM) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if tc.CAFile != "" {
+			pem, err := os.ReadFile(tc.CAFile)
+			if err != nil {
+				return nil, &ConfigError{Field: "TLS.CAFile", Reason: err.Error()}
+			}
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, &ConfigError{Field: "TLS.CAFile", Reason: "no PEM certificates found in " + tc.CAFile}
+			}
+		}
+		if len(tc.CAPEM) > 0 && !config.RootCAs.AppendCertsFromPEM(tc.CAPEM) {
+			return nil, &ConfigError{Field: "TLS.CAPEM", Reason: "no PEM certificates found"}
+		}
+	}
+
+	if tc.CertFile != "" || tc.KeyFile != "" {
+		if tc.CertFile == "" || tc.KeyFile == "" {
+			return nil, &ConfigError{Field: "TLS.CertFile", Reason: "CertFile and KeyFile must be set together"}
+		}
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, &ConfigError{Field: "TLS.CertFile", Reason: err.Error()}
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(tc.PinnedPublicKeys) > 0 {
+		pins := make(map[[sha256.Size]byte]bool, len(tc.PinnedPublicKeys))
+		for _, pin := range tc.PinnedPublicKeys {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+			if err != nil || len(hash) != sha256.Size {
+				return nil, &ConfigError{Field: "TLS.PinnedPublicKeys", Reason: fmt.Sprintf("%q is not a base64 encoded SHA-256 hash", pin)}
+			}
+			pins[[sha256.Size]byte(hash)] = true
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs, pins)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyPins checks that a verified chain of the connection contains a
+// pinned public key. Certificates the server sent that are not part of a
+// verified chain are ignored, so they cannot satisfy a pin.
+func verifyPins(cs tls.ConnectionState, pins map[[sha256.Size]byte]bool) error {
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+		}
+	}
+	return ErrPinMismatch
+}
@@ -0,0 +1,232 @@
+package webdavfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// newTLSServer starts a WebDAV server over TLS and returns it with the PEM
+// encoding of its certificate. configure may adjust the server's TLS
+// configuration before it starts.
+func newTLSServer(t *testing.T, configure func(*tls.Config)) (*httptest.Server, []byte) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	// Rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.TLS = &tls.Config{}
+	if configure != nil {
+		configure(server.TLS)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return server, caPEM
+}
+
+// writeClientCert creates a self-signed client certificate and writes it and
+// its key to dir
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webdavfs client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// spkiPin returns the pin of a certificate's public key
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestTLSConfig_CustomCA(t *testing.T) {
+	server, caPEM := newTLSServer(t, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []*TLSConfig{{CAFile: caFile}, {CAPEM: caPEM}} {
+		fs, err := New(&Config{URL: server.URL, TLS: tc})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if err := fs.Mkdir("/dir", 0755); err != nil {
+			t.Errorf("Mkdir() with trusted CA error = %v", err)
+		}
+		fs.RemoveAll("/dir")
+	}
+
+	// Without the CA the server's certificate is rejected
+	fs, err := New(&Config{URL: server.URL, TLS: &TLSConfig{}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); err == nil {
+		t.Error("Stat() with untrusted certificate expected error")
+	}
+}
+
+func TestTLSConfig_ServerName(t *testing.T) {
+	server, caPEM := newTLSServer(t, nil)
+
+	// The httptest certificate is valid for example.com
+	fs, err := New(&Config{URL: server.URL, TLS: &TLSConfig{CAPEM: caPEM, ServerName: "example.com"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	}
+
+	fs, err = New(&Config{URL: server.URL, TLS: &TLSConfig{CAPEM: caPEM, ServerName: "other.example.org"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); err == nil {
+		t.Error("Stat() with mismatched server name expected error")
+	}
+}
+
+func TestTLSConfig_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+	server, caPEM := newTLSServer(t, func(c *tls.Config) {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = x509.NewCertPool()
+		c.ClientCAs.AddCert(clientCert)
+	})
+
+	fs, err := New(&Config{URL: server.URL, TLS: &TLSConfig{CAPEM: caPEM, CertFile: certFile, KeyFile: keyFile}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); err != nil {
+		t.Errorf("Stat() with client certificate error = %v", err)
+	}
+
+	fs, err = New(&Config{URL: server.URL, TLS: &TLSConfig{CAPEM: caPEM}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); err == nil {
+		t.Error("Stat() without client certificate expected error")
+	}
+}
+
+func TestTLSConfig_PinnedPublicKeys(t *testing.T) {
+	server, caPEM := newTLSServer(t, nil)
+	_, _, other := writeClientCert(t, t.TempDir())
+
+	fs, err := New(&Config{URL: server.URL, TLS: &TLSConfig{
+		CAPEM:            caPEM,
+		PinnedPublicKeys: []string{spkiPin(other), spkiPin(server.Certificate())},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); err != nil {
+		t.Errorf("Stat() with matching pin error = %v", err)
+	}
+
+	fs, err = New(&Config{URL: server.URL, Retry: &RetryPolicy{}, TLS: &TLSConfig{
+		CAPEM:            caPEM,
+		PinnedPublicKeys: []string{spkiPin(other)},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := fs.Stat("/"); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("Stat() with mismatched pin error = %v, want ErrPinMismatch", err)
+	}
+}
+
+func TestTLSConfig_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _, _ := writeClientCert(t, dir)
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		field  string
+	}{
+		{"missing CA file", &Config{TLS: &TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}}, "TLS.CAFile"},
+		{"CA file without certificates", &Config{TLS: &TLSConfig{CAFile: garbage}}, "TLS.CAFile"},
+		{"CA PEM without certificates", &Config{TLS: &TLSConfig{CAPEM: []byte("garbage")}}, "TLS.CAPEM"},
+		{"certificate without key", &Config{TLS: &TLSConfig{CertFile: certFile}}, "TLS.CertFile"},
+		{"key not matching certificate", &Config{TLS: &TLSConfig{CertFile: certFile, KeyFile: garbage}}, "TLS.CertFile"},
+		{"malformed pin", &Config{TLS: &TLSConfig{PinnedPublicKeys: []string{"sha256/abc"}}}, "TLS.PinnedPublicKeys"},
+		{"unknown version", &Config{TLS: &TLSConfig{MinVersion: 0x0305}}, "TLS.MinVersion"},
+		{"custom HTTP client", &Config{TLS: &TLSConfig{}, HTTPClient: &http.Client{}}, "TLS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.URL = "https://example.com"
+			_, err := New(tt.config)
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("New() error = %v, want *ConfigError", err)
+			}
+			if configErr.Field != tt.field {
+				t.Errorf("ConfigError.Field = %q, want %q", configErr.Field, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package webdavfs
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryLeeway is how long before its expiry a token is replaced, so
+// that it does not expire while a request is in flight
+const tokenExpiryLeeway = 10 * time.Second
+
+// Token is an access token for Bearer authentication. Its fields match
+// those of golang.org/x/oauth2.Token.
+type Token struct {
+	// AccessToken is the token sent to the server
+	AccessToken string
+
+	// TokenType is the authorization scheme (default: "Bearer")
+	TokenType string
+
+	// Expiry is when the token expires; zero means it does not expire
+	Expiry time.Time
+}
+
+// TokenSource supplies access tokens. A golang.org/x/oauth2.TokenSource
+// returns *oauth2.Token rather than *Token, so it needs an adapter such as
+// a TokenSourceFunc that copies the fields; this package does not depend on
+// oauth2.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// TokenSourceFunc adapts a function to the TokenSource interface
+type TokenSourceFunc func() (*Token, error)
+
+// Token implements TokenSource
+func (f TokenSourceFunc) Token() (*Token, error) {
+	return f()
+}
+
+// errNoAccessToken is returned when a TokenSource returns no token without
+// an error
+var errNoAccessToken = errors.New("token source returned no access token")
+
+// tokenAuthenticator authenticates requests with tokens from a TokenSource.
+// A token is reused until shortly before it expires. If the server rejects
+// it, the token is discarded and the request retried once with a new one.
+type tokenAuthenticator struct {
+	src TokenSource
+	now func() time.Time
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// Authorize implements Authenticator
+func (a *tokenAuthenticator) Authorize(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == nil || a.expired(a.token) {
+		token, err := a.src.Token()
+		if err != nil {
+			return err
+		}
+		if token == nil || token.AccessToken == "" {
+			return errNoAccessToken
+		}
+		a.token = token
+	}
+	req.Header.Set("Authorization", authorizationHeader(a.token))
+	return nil
+}
+
+// Challenge implements Authenticator. It discards the rejected token unless
+// it was already replaced by a concurrent request.
+func (a *tokenAuthenticator) Challenge(resp *http.Response) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != nil && resp.Request != nil && resp.Request.Header.Get("Authorization") == authorizationHeader(a.token) {
+		a.token = nil
+	}
+	return true, nil
+}
+
+// expired reports whether token is about to expire
+func (a *tokenAuthenticator) expired(token *Token) bool {
+	return !token.Expiry.IsZero() && !a.now().Add(tokenExpiryLeeway).Before(token.Expiry)
+}
+
+// authorizationHeader returns the Authorization header value for token
+func authorizationHeader(token *Token) string {
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + token.AccessToken
+}
@@ -0,0 +1,140 @@
+package webdavfs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenSource_RefreshOn401(t *testing.T) {
+	var mu sync.Mutex
+	valid := "token-2"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.Header.Get("Authorization") == "Bearer "+valid
+		mu.Unlock()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	issued := 0
+	src := TokenSourceFunc(func() (*Token, error) {
+		issued++
+		return &Token{AccessToken: fmt.Sprintf("token-%d", issued), TokenType: "bearer"}, nil
+	})
+
+	fs, err := New(&Config{URL: server.URL, TokenSource: src})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// token-1 was revoked; the client refreshes once and retries
+	if err := fs.client.mkcol(fs.context(), "/dir"); err != nil {
+		t.Fatalf("mkcol() error = %v", err)
+	}
+	if issued != 2 {
+		t.Errorf("issued %d tokens, want 2", issued)
+	}
+
+	// The refreshed token is reused
+	if err := fs.client.mkcol(fs.context(), "/dir2"); err != nil {
+		t.Fatalf("mkcol() error = %v", err)
+	}
+	if issued != 2 {
+		t.Errorf("issued %d tokens, want the token to be reused", issued)
+	}
+
+	// A token that is rejected even after refreshing fails without a loop
+	mu.Lock()
+	valid = "never"
+	mu.Unlock()
+	if err := fs.client.mkcol(fs.context(), "/dir3"); err == nil {
+		t.Error("mkcol() with rejected tokens expected error")
+	}
+	if issued != 3 {
+		t.Errorf("issued %d tokens, want exactly one refresh", issued)
+	}
+}
+
+func TestTokenAuthenticator_Expiry(t *testing.T) {
+	now := time.Now()
+	issued := 0
+	a := &tokenAuthenticator{
+		src: TokenSourceFunc(func() (*Token, error) {
+			issued++
+			return &Token{AccessToken: fmt.Sprint(issued), Expiry: now.Add(time.Minute)}, nil
+		}),
+		now: func() time.Time { return now },
+	}
+
+	authorize := func() string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		if err := a.Authorize(req); err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := authorize(); got != "Bearer 1" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer 1")
+	}
+	if got := authorize(); got != "Bearer 1" {
+		t.Errorf("Authorization = %q, want the cached token", got)
+	}
+
+	// Shortly before expiry the token is replaced
+	now = now.Add(time.Minute - tokenExpiryLeeway/2)
+	if got := authorize(); got != "Bearer 2" {
+		t.Errorf("Authorization = %q, want a fresh token", got)
+	}
+}
+
+func TestConfig_TokenSourceExclusive(t *testing.T) {
+	src := TokenSourceFunc(func() (*Token, error) { return &Token{AccessToken: "x"}, nil })
+	for _, config := range []*Config{
+		{URL: "http://example.com", TokenSource: src, Username: "user", Password: "pass"},
+		{URL: "http://example.com", TokenSource: src, BearerToken: "static"},
+		{URL: "http://example.com", TokenSource: src, Auth: &BasicAuthenticator{}},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("New(%+v) expected *ConfigError", config)
+		} else if _, ok := err.(*ConfigError); !ok {
+			t.Errorf("New() error = %v, want *ConfigError", err)
+		}
+	}
+}
+
+func TestTokenAuthenticator_NoToken(t *testing.T) {
+	for _, token := range []*Token{nil, {TokenType: "Bearer"}} {
+		issued := 0
+		a := &tokenAuthenticator{
+			src: TokenSourceFunc(func() (*Token, error) {
+				issued++
+				return token, nil
+			}),
+			now: time.Now,
+		}
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			if err := a.Authorize(req); err == nil {
+				t.Errorf("Authorize() with token %+v succeeded", token)
+			}
+			if got := req.Header.Get("Authorization"); got != "" {
+				t.Errorf("Authorization = %q, want none", got)
+			}
+		}
+		// The missing token is not cached, so the source is asked again
+		if issued != 2 {
+			t.Errorf("source asked %d times, want 2", issued)
+		}
+	}
+}
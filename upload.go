@@ -0,0 +1,74 @@
+package webdavfs
+
+import (
+	"context"
+	"io"
+)
+
+// uploader receives the body of a streaming upload. finish ends the body,
+// reports whether the server stored the file and returns its new ETag if the
+// server reported one. abort cancels the upload, leaving the file unchanged.
+type uploader interface {
+	io.Writer
+	finish() (string, error)
+	abort(err error)
+}
+
+// streamUpload is a PUT request whose body is fed incrementally through a
+// pipe, so that sequential writers never hold the whole file in memory
+type streamUpload struct {
+	pw   *io.PipeWriter
+	done chan uploadResult
+}
+
+// uploadResult is the outcome of a streamed PUT
+type uploadResult struct {
+	etag string
+	err  error
+}
+
+// startStreamUpload begins an upload of pathStr whose body is everything
+// written to the returned uploader until it is finished. With chunked uploads
+// configured, the data is sent in chunks; otherwise it streams as one PUT.
+// The upload only replaces the file if the precondition holds.
+func (c *webdavClient) startStreamUpload(ctx context.Context, pathStr string, pre precondition) uploader {
+	if c.chunkedUploadsEnabled() {
+		return &chunkedStream{c: c, ctx: ctx, pathStr: pathStr, pre: pre}
+	}
+
+	pr, pw := io.Pipe()
+	u := &streamUpload{
+		pw:   pw,
+		done: make(chan uploadResult, 1),
+	}
+
+	go func() {
+		etag, err := c.putCond(ctx, pathStr, pr, pre)
+		// Unblock the writer if the server finished early or failed
+		pr.CloseWithError(err)
+		u.done <- uploadResult{etag, err}
+	}()
+
+	return u
+}
+
+// Write sends b as the next part of the request body. If the request has
+// already failed, the error reported by the server is returned.
+func (u *streamUpload) Write(b []byte) (int, error) {
+	return u.pw.Write(b)
+}
+
+// finish ends the request body and waits for the server's response
+func (u *streamUpload) finish() (string, error) {
+	u.pw.Close()
+	r := <-u.done
+	return r.etag, r.err
+}
+
+// abort fails the request body with err, so that the server never sees a
+// complete request, and waits for the request to end. finish must not be
+// called afterwards.
+func (u *streamUpload) abort(err error) {
+	u.pw.CloseWithError(err)
+	<-u.done
+}
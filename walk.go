@@ -0,0 +1,436 @@
+package webdavfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WalkDir walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root. It follows the rules of
+// io/fs.WalkDir: files are visited in lexical order, directories before
+// their contents, and fn may return fs.SkipDir or fs.SkipAll.
+//
+// The whole tree is first requested with a single PROPFIND of
+// Depth: infinity. Servers often refuse such requests (403 with the
+// propfind-finite-depth precondition); the tree is then crawled one
+// directory at a time, listing up to Config.WalkConcurrency directories in
+// parallel ahead of fn and holding at most a few times that many listings
+// fn has not reached yet. Either way the listings are added to the
+// metadata cache, if enabled.
+func (fs *FileSystem) WalkDir(root string, fn iofs.WalkDirFunc) error {
+	root = strings.ReplaceAll(root, "\\", "/")
+	ctx, cancel := context.WithCancel(fs.context())
+	defer cancel()
+
+	w := &walker{fn: fn}
+	info, err := fs.client.walkTree(ctx, fs.cleanPath(root), w)
+	if err == nil {
+		err = w.walk(root, fs.cleanPath(root), dirEntry{info})
+	} else {
+		err = fn(root, nil, err)
+	}
+	cancel()
+	if w.crawl != nil {
+		w.crawl.wait()
+	}
+
+	if err == iofs.SkipDir || err == iofs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// Walk walks the file tree rooted at root like WalkDir, calling fn with the
+// FileInfo of each file or directory in the manner of filepath.Walk. The
+// FileInfo comes from the listing of the parent directory, so no further
+// request is made for it.
+func (fs *FileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+		var info os.FileInfo
+		if d != nil {
+			info, _ = d.Info()
+		}
+		return fn(name, info, err)
+	})
+}
+
+// walker visits a tree in the order of io/fs.WalkDir. The listings come
+// either from a tree fetched with Depth: infinity or from a crawl.
+type walker struct {
+	fn    iofs.WalkDirFunc
+	tree  map[string][]os.FileInfo // Listings by directory path, if fetched whole
+	crawl *crawler
+}
+
+// walk visits the file or directory d, named name in calls to fn and found
+// at pathStr on the server
+func (w *walker) walk(name, pathStr string, d iofs.DirEntry) error {
+	if err := w.fn(name, d, nil); err != nil || !d.IsDir() {
+		if err == iofs.SkipDir && d.IsDir() {
+			w.skip(pathStr)
+			err = nil
+		}
+		return err
+	}
+
+	infos, err := w.list(pathStr)
+	if err != nil {
+		if err = w.fn(name, d, err); err != nil {
+			if err == iofs.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	for _, info := range infos {
+		err := w.walk(path.Join(name, info.Name()), path.Join(pathStr, info.Name()), dirEntry{info})
+		if err != nil {
+			if err == iofs.SkipDir {
+				// A file returning SkipDir skips the rest of its directory
+				w.skip(pathStr)
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns the entries of the directory pathStr
+func (w *walker) list(pathStr string) ([]os.FileInfo, error) {
+	if w.crawl != nil {
+		return w.crawl.listing(pathStr)
+	}
+	return w.tree[pathStr], nil
+}
+
+// skip stops the crawl from listing pathStr and its descendants
+func (w *walker) skip(pathStr string) {
+	if w.crawl != nil {
+		w.crawl.skip(pathStr)
+	}
+}
+
+// walkTree prepares w to walk the tree rooted at pathStr and returns the
+// FileInfo of the root. It fetches the whole tree with Depth: infinity,
+// unless the server refuses, in which case it starts a crawl.
+func (c *webdavClient) walkTree(ctx context.Context, pathStr string, w *walker) (os.FileInfo, error) {
+	if !c.finiteDepth.Load() {
+		root, tree, err := c.propfindTree(ctx, pathStr)
+		if err == nil {
+			w.tree = tree
+			return root, nil
+		}
+		refused, always := refusesInfiniteDepth(err)
+		if !refused {
+			return nil, err
+		}
+		if always {
+			c.finiteDepth.Store(true)
+		}
+	}
+
+	root, err := c.stat(ctx, pathStr)
+	if err != nil {
+		return nil, err
+	}
+	if root.IsDir() {
+		w.crawl = newCrawler(ctx, c, pathStr)
+	}
+	return root, nil
+}
+
+// refusesInfiniteDepth reports whether err may be a server's refusal to
+// answer a PROPFIND with Depth: infinity, and whether the server will
+// refuse every such request. RFC 4918 prescribes 403 with the
+// propfind-finite-depth precondition; some servers answer 400 or 501
+// instead. A 403 without the precondition may just deny access to this
+// tree, so the walk crawls it, where listing the root with Depth: 1 reports
+// the error properly, but later walks try Depth: infinity again.
+func refusesInfiniteDepth(err error) (refused, always bool) {
+	var de *WebDAVError
+	if !errors.As(err, &de) {
+		return false, false
+	}
+	switch de.StatusCode {
+	case http.StatusForbidden:
+		return true, strings.Contains(de.Message, "propfind-finite-depth")
+	case http.StatusBadRequest, http.StatusNotImplemented:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// propfindTree lists the tree rooted at pathStr with a single PROPFIND of
+// Depth: infinity. The responses are decoded one at a time as they arrive,
+// and returned as the listing of each directory.
+func (c *webdavClient) propfindTree(ctx context.Context, pathStr string) (os.FileInfo, map[string][]os.FileInfo, error) {
+	resp, err := c.propfindRequest(ctx, pathStr, depthInfinity, buildPropfindBody(c.extraProps...))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	rootURL, err := c.buildURL(pathStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var root os.FileInfo
+	infos := make(map[string]os.FileInfo)
+	tree := make(map[string][]os.FileInfo)
+	ms := newMultistatusReader(resp.Body)
+	for {
+		r, err := ms.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, &os.PathError{Op: "propfind", Path: pathStr, Err: contextError(ctx, err)}
+		}
+
+		rel, ok := relativeHref(rootURL, r.href())
+		if !ok {
+			continue
+		}
+		p := path.Join(pathStr, rel)
+		info, err := parseFileInfo(r, p)
+		if err != nil {
+			// A member the server does not let us list, e.g. 403
+			continue
+		}
+		if rel == "" {
+			root = info
+		} else {
+			tree[path.Dir(p)] = append(tree[path.Dir(p)], info)
+		}
+		infos[p] = info
+	}
+	if root == nil {
+		return nil, nil, &os.PathError{Op: "stat", Path: pathStr, Err: os.ErrNotExist}
+	}
+
+	for p, info := range infos {
+		if info.IsDir() {
+			c.cache.putListing(p, info, tree[p])
+		}
+	}
+	c.cache.putStat(pathStr, root)
+	return root, tree, nil
+}
+
+// crawlLookahead bounds the listings a crawl holds ahead of the walker, as
+// a multiple of the number of workers
+const crawlLookahead = 4
+
+// crawler lists the directories of a tree breadth first, with a bounded
+// number of requests in flight, while the walker consumes the listings in
+// depth-first order. At most limit listings are requested or held without
+// the walker having consumed them; a directory the walker waits for is
+// listed regardless.
+type crawler struct {
+	ctx     context.Context
+	c       *webdavClient
+	workers int
+	limit   int
+
+	mu      sync.Mutex
+	queue   []*crawlDir          // Directories waiting to be listed, in order
+	dirs    map[string]*crawlDir // Listings not yet consumed by the walker
+	skipped map[string]bool      // Directories the walker skipped
+	want    *crawlDir            // Directory the walker waits for, if not started
+	held    int                  // Listings started and not yet consumed
+	running int
+	wg      sync.WaitGroup
+}
+
+// crawlDir is the listing of one directory, complete once done is closed
+type crawlDir struct {
+	path    string
+	done    chan struct{}
+	entries []os.FileInfo
+	err     error
+	started bool // Taken off the queue
+	held    bool // Counted in crawler.held
+}
+
+// newCrawler starts listing the tree rooted at the directory pathStr
+func newCrawler(ctx context.Context, c *webdavClient, pathStr string) *crawler {
+	cr := &crawler{
+		ctx:     ctx,
+		c:       c,
+		workers: c.walkConcurrency,
+		limit:   crawlLookahead * c.walkConcurrency,
+		dirs:    make(map[string]*crawlDir),
+		skipped: make(map[string]bool),
+	}
+	cr.mu.Lock()
+	cr.enqueue(pathStr)
+	cr.spawn()
+	cr.mu.Unlock()
+	return cr
+}
+
+// enqueue schedules the directory pathStr to be listed. cr.mu must be held.
+func (cr *crawler) enqueue(pathStr string) {
+	d := &crawlDir{path: pathStr, done: make(chan struct{})}
+	cr.dirs[pathStr] = d
+	cr.queue = append(cr.queue, d)
+}
+
+// spawn starts workers while fewer than the maximum are running and there
+// is a directory they may list. cr.mu must be held.
+func (cr *crawler) spawn() {
+	for cr.running < cr.workers && ((cr.want != nil && !cr.want.started) || (len(cr.queue) > 0 && cr.held < cr.limit)) {
+		cr.running++
+		cr.wg.Add(1)
+		go cr.work()
+	}
+}
+
+// next takes the next directory to list off the queue, or returns nil if
+// there is none or too many listings are held already. cr.mu must be held.
+func (cr *crawler) next() *crawlDir {
+	if d := cr.want; d != nil {
+		cr.want = nil
+		if !d.started {
+			d.started, d.held = true, true
+			cr.held++
+			return d
+		}
+	}
+	for len(cr.queue) > 0 {
+		d := cr.queue[0]
+		if d.started {
+			cr.queue = cr.queue[1:]
+			continue
+		}
+		if cr.isSkipped(d.path) || cr.ctx.Err() != nil {
+			// Nobody is waiting for the listing any more
+			cr.queue = cr.queue[1:]
+			d.started = true
+			close(d.done)
+			continue
+		}
+		if cr.held >= cr.limit {
+			return nil
+		}
+		cr.queue = cr.queue[1:]
+		d.started, d.held = true, true
+		cr.held++
+		return d
+	}
+	return nil
+}
+
+// work lists directories until there are none it may list
+func (cr *crawler) work() {
+	defer cr.wg.Done()
+	for {
+		cr.mu.Lock()
+		d := cr.next()
+		if d == nil {
+			cr.running--
+			cr.mu.Unlock()
+			return
+		}
+		cr.mu.Unlock()
+
+		entries, err := cr.c.readDir(cr.ctx, d.path)
+		cr.mu.Lock()
+		d.entries, d.err = entries, err
+		if !cr.isSkipped(d.path) {
+			for _, info := range entries {
+				if info.IsDir() {
+					cr.enqueue(path.Join(d.path, info.Name()))
+				}
+			}
+			cr.spawn()
+		}
+		cr.mu.Unlock()
+		close(d.done)
+	}
+}
+
+// isSkipped reports whether pathStr or one of its ancestors was skipped.
+// cr.mu must be held.
+func (cr *crawler) isSkipped(pathStr string) bool {
+	for p := pathStr; ; p = path.Dir(p) {
+		if cr.skipped[p] {
+			return true
+		}
+		if p == "/" || p == "." {
+			return false
+		}
+	}
+}
+
+// listing waits for the listing of the directory pathStr, which is listed
+// next if that has not started yet
+func (cr *crawler) listing(pathStr string) ([]os.FileInfo, error) {
+	cr.mu.Lock()
+	d, ok := cr.dirs[pathStr]
+	delete(cr.dirs, pathStr)
+	if ok && !d.started {
+		cr.want = d
+		cr.spawn()
+	}
+	cr.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	select {
+	case <-d.done:
+	case <-cr.ctx.Done():
+		return nil, &os.PathError{Op: "readdir", Path: pathStr, Err: cr.ctx.Err()}
+	}
+	cr.mu.Lock()
+	cr.release(d)
+	cr.mu.Unlock()
+	return d.entries, d.err
+}
+
+// release stops counting the listing d, which the walker consumed or
+// skipped, towards the limit. cr.mu must be held.
+func (cr *crawler) release(d *crawlDir) {
+	if d.held {
+		d.held = false
+		cr.held--
+		cr.spawn()
+	}
+}
+
+// skip prevents pathStr and its descendants from being listed, unless that
+// is already underway
+func (cr *crawler) skip(pathStr string) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.skipped[pathStr] = true
+	for p, d := range cr.dirs {
+		if p == pathStr || isAncestor(pathStr, p) {
+			delete(cr.dirs, p)
+			cr.release(d)
+		}
+	}
+}
+
+// wait waits for the workers to finish. The crawl's context must have been
+// cancelled, so that they do not start further requests.
+func (cr *crawler) wait() {
+	cr.wg.Wait()
+}
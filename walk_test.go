@@ -0,0 +1,328 @@
+package webdavfs
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// walkServer is a WebDAV server below /dav/ that counts PROPFIND requests
+// by depth and can refuse Depth: infinity like most production servers
+type walkServer struct {
+	*httptest.Server
+	finiteDepth bool
+
+	mu        sync.Mutex
+	depths    map[string]int
+	inFlight  int
+	maxLists  int    // Most Depth: 1 requests in flight at once
+	forbidden string // URL path answering Depth: infinity with a bare 403
+}
+
+func newWalkServer(t *testing.T, finiteDepth bool) *walkServer {
+	t.Helper()
+	s := &walkServer{finiteDepth: finiteDepth, depths: make(map[string]int)}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		depth := r.Header.Get("Depth")
+		s.mu.Lock()
+		s.depths[depth]++
+		forbidden := s.forbidden != "" && r.URL.Path == s.forbidden
+		s.mu.Unlock()
+
+		switch {
+		case depth == "infinity" && forbidden:
+			w.WriteHeader(http.StatusForbidden)
+		case depth == "infinity" && s.finiteDepth:
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<?xml version="1.0"?><d:error xmlns:d="DAV:"><d:propfind-finite-depth/></d:error>`))
+		case depth == "1":
+			s.mu.Lock()
+			s.inFlight++
+			s.maxLists = max(s.maxLists, s.inFlight)
+			s.mu.Unlock()
+			// Give other listings the chance to overlap
+			time.Sleep(5 * time.Millisecond)
+			handler.ServeHTTP(w, r)
+			s.mu.Lock()
+			s.inFlight--
+			s.mu.Unlock()
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *walkServer) propfinds(depth string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depths[depth]
+}
+
+func (s *walkServer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.depths = make(map[string]int)
+}
+
+// newWalkTree creates a small tree on the server and returns a FileSystem
+// for it
+func newWalkTree(t *testing.T, s *walkServer, config *Config) *FileSystem {
+	t.Helper()
+	config.URL = s.URL + "/dav/"
+	fs, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, dir := range []string{"/a", "/a/sub", "/b", "/b/x", "/b/y", "/empty", "/with space"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir(%s) error = %v", dir, err)
+		}
+	}
+	for _, file := range []string{"/a/1.txt", "/a/sub/2.txt", "/b/x/3.txt", "/b/y/4.txt", "/top.txt", "/with space/5%.txt"} {
+		if err := fs.WriteFile(file, []byte(file), 0644); err != nil {
+			t.Fatalf("WriteFile(%s) error = %v", file, err)
+		}
+	}
+	s.reset()
+	return fs
+}
+
+// walkPaths walks root and returns the visited paths, marking directories
+// with a trailing slash
+func walkPaths(t *testing.T, fs *FileSystem, root string, skip map[string]error) []string {
+	t.Helper()
+	var paths []string
+	err := fs.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			t.Fatalf("WalkDir(%s) callback error = %v", name, err)
+		}
+		if d.IsDir() {
+			name += "/"
+		}
+		paths = append(paths, name)
+		return skip[name]
+	})
+	if err != nil {
+		t.Fatalf("WalkDir() error = %v", err)
+	}
+	return paths
+}
+
+var wantWalk = []string{
+	"//",
+	"/a/", "/a/1.txt", "/a/sub/", "/a/sub/2.txt",
+	"/b/", "/b/x/", "/b/x/3.txt", "/b/y/", "/b/y/4.txt",
+	"/empty/",
+	"/top.txt",
+	"/with space/", "/with space/5%.txt",
+}
+
+func TestFileSystem_WalkDirInfiniteDepth(t *testing.T) {
+	s := newWalkServer(t, false)
+	fs := newWalkTree(t, s, &Config{})
+
+	if got := walkPaths(t, fs, "/", nil); !reflect.DeepEqual(got, wantWalk) {
+		t.Errorf("WalkDir() visited\n%q\nwant\n%q", got, wantWalk)
+	}
+	if n := s.propfinds("infinity"); n != 1 {
+		t.Errorf("Depth: infinity PROPFINDs = %d, want 1", n)
+	}
+	if n := s.propfinds("1"); n != 0 {
+		t.Errorf("Depth: 1 PROPFINDs = %d, want 0", n)
+	}
+}
+
+func TestFileSystem_WalkDirFiniteDepth(t *testing.T) {
+	s := newWalkServer(t, true)
+	fs := newWalkTree(t, s, &Config{WalkConcurrency: 2})
+
+	if got := walkPaths(t, fs, "/", nil); !reflect.DeepEqual(got, wantWalk) {
+		t.Errorf("WalkDir() visited\n%q\nwant\n%q", got, wantWalk)
+	}
+	if n := s.propfinds("1"); n != 8 {
+		t.Errorf("Depth: 1 PROPFINDs = %d, want one per directory (8)", n)
+	}
+	s.mu.Lock()
+	maxLists := s.maxLists
+	s.mu.Unlock()
+	if maxLists > 2 {
+		t.Errorf("%d listings in flight, want at most WalkConcurrency (2)", maxLists)
+	}
+
+	// The refusal is remembered
+	s.reset()
+	walkPaths(t, fs, "/b", nil)
+	if n := s.propfinds("infinity"); n != 0 {
+		t.Errorf("Depth: infinity PROPFINDs after refusal = %d, want 0", n)
+	}
+}
+
+func TestFileSystem_WalkDirForbidden(t *testing.T) {
+	s := newWalkServer(t, false)
+	fs := newWalkTree(t, s, &Config{})
+	s.mu.Lock()
+	s.forbidden = "/dav/b"
+	s.mu.Unlock()
+
+	// A 403 without the propfind-finite-depth precondition falls back to a
+	// crawl of this tree only
+	want := []string{"/b/", "/b/x/", "/b/x/3.txt", "/b/y/", "/b/y/4.txt"}
+	if got := walkPaths(t, fs, "/b", nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir() visited\n%q\nwant\n%q", got, want)
+	}
+	if n := s.propfinds("1"); n != 3 {
+		t.Errorf("Depth: 1 PROPFINDs = %d, want 3", n)
+	}
+
+	s.reset()
+	walkPaths(t, fs, "/a", nil)
+	if n := s.propfinds("infinity"); n != 1 {
+		t.Errorf("Depth: infinity PROPFINDs after a bare 403 = %d, want 1", n)
+	}
+}
+
+func TestFileSystem_WalkDirLookahead(t *testing.T) {
+	s := newWalkServer(t, true)
+	fs := newWalkTree(t, s, &Config{WalkConcurrency: 2})
+	for i := 0; i < 40; i++ {
+		dir := fmt.Sprintf("/wide/%02d", i)
+		if err := fs.MkdirAll(dir+"/sub", 0755); err != nil {
+			t.Fatalf("MkdirAll(%s) error = %v", dir, err)
+		}
+	}
+	s.reset()
+
+	// While fn dwells on the root, the crawl lists only a bounded number of
+	// directories ahead of it
+	var listed int
+	visited := 0
+	err := fs.WalkDir("/wide", func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if visited == 0 {
+			time.Sleep(300 * time.Millisecond)
+			listed = s.propfinds("1")
+		}
+		visited++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir() error = %v", err)
+	}
+	if limit := crawlLookahead * 2; listed > limit {
+		t.Errorf("%d directories listed ahead of fn, want at most %d", listed, limit)
+	}
+	if visited != 81 {
+		t.Errorf("visited %d entries, want 81", visited)
+	}
+}
+
+func TestFileSystem_WalkDirSkip(t *testing.T) {
+	for _, finite := range []bool{false, true} {
+		s := newWalkServer(t, finite)
+		fs := newWalkTree(t, s, &Config{})
+
+		got := walkPaths(t, fs, "/", map[string]error{
+			"/a/":          iofs.SkipDir, // Skips the directory
+			"/b/x/3.txt":   iofs.SkipDir, // Skips the rest of /b/x
+			"/b/y/":        iofs.SkipDir,
+			"/with space/": iofs.SkipAll,
+		})
+		want := []string{"//", "/a/", "/b/", "/b/x/", "/b/x/3.txt", "/b/y/", "/empty/", "/top.txt", "/with space/"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("finite depth %v: WalkDir() visited\n%q\nwant\n%q", finite, got, want)
+		}
+	}
+}
+
+func TestFileSystem_WalkDirSubtree(t *testing.T) {
+	s := newWalkServer(t, false)
+	fs := newWalkTree(t, s, &Config{})
+	if err := fs.Chdir("/b"); err != nil {
+		t.Fatalf("Chdir() error = %v", err)
+	}
+
+	got := walkPaths(t, fs, "x", nil)
+	want := []string{"x/", "x/3.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir() visited %q, want %q", got, want)
+	}
+
+	got = walkPaths(t, fs, "/top.txt", nil)
+	if want := []string{"/top.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir() of a file visited %q, want %q", got, want)
+	}
+}
+
+func TestFileSystem_WalkDirMissingRoot(t *testing.T) {
+	for _, finite := range []bool{false, true} {
+		s := newWalkServer(t, finite)
+		fs := newWalkTree(t, s, &Config{})
+
+		var calls int
+		err := fs.WalkDir("/missing", func(name string, d iofs.DirEntry, err error) error {
+			calls++
+			if d != nil || !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("callback(%s, %v, %v), want nil entry and ErrNotExist", name, d, err)
+			}
+			return err
+		})
+		if calls != 1 || !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("finite depth %v: WalkDir() = %v after %d calls, want ErrNotExist after 1", finite, err, calls)
+		}
+	}
+}
+
+func TestFileSystem_Walk(t *testing.T) {
+	s := newWalkServer(t, true)
+	fs := newWalkTree(t, s, &Config{MetadataCache: &MetadataCacheConfig{}})
+
+	sizes := make(map[string]int64)
+	err := fs.Walk("/b", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			sizes[name] = info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := map[string]int64{"/b/x/3.txt": 10, "/b/y/4.txt": 10}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Walk() sizes = %v, want %v", sizes, want)
+	}
+
+	// The listings were cached
+	s.reset()
+	if _, err := fs.ReadDir("/b/x"); err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if n := s.propfinds("1"); n != 0 {
+		t.Errorf("ReadDir() after Walk sent %d PROPFINDs, want 0", n)
+	}
+}
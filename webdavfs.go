@@ -3,11 +3,13 @@ package webdavfs
 
 import (
 	"bytes"
+	"context"
 	"io"
 	iofs "io/fs"
 	"os"
 	"path"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/absfs/absfs"
@@ -15,10 +17,13 @@ import (
 
 // FileSystem implements the absfs.FileSystem interface for WebDAV servers
 type FileSystem struct {
-	client  *webdavClient
-	root    string
-	cwd     string
-	tempDir string
+	client         *webdavClient
+	ctx            context.Context
+	root           string
+	cwd            string
+	tempDir        string
+	spoolThreshold int64
+	spoolDir       string
 }
 
 // New creates a new WebDAV filesystem
@@ -27,11 +32,11 @@ func New(config *Config) (*FileSystem, error) {
 		return nil, &ConfigError{Field: "config", Reason: "config cannot be nil"}
 	}
 
-	// Set defaults and validate
-	config.setDefaults()
+	// Validate and set defaults
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
+	config.setDefaults()
 
 	// Create WebDAV client
 	client, err := newWebDAVClient(config)
@@ -39,14 +44,50 @@ func New(config *Config) (*FileSystem, error) {
 		return nil, err
 	}
 
+	if config.VerifyOnConnect {
+		if err := client.verifyWebDAV(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
 	return &FileSystem{
-		client:  client,
-		root:    "/",
-		cwd:     "/",
-		tempDir: config.TempDir,
+		client:         client,
+		root:           "/",
+		cwd:            "/",
+		tempDir:        config.TempDir,
+		spoolThreshold: config.SpoolThreshold,
+		spoolDir:       config.SpoolDir,
 	}, nil
 }
 
+// WithContext returns a shallow copy of fs whose requests are bound to ctx.
+// Cancelling ctx aborts any in-flight request made through the returned
+// FileSystem or through files opened from it, including response bodies
+// still being streamed by File.Read. The copy starts in the same working
+// directory as fs but tracks it independently.
+func (fs *FileSystem) WithContext(ctx context.Context) *FileSystem {
+	if ctx == nil {
+		panic("webdavfs: nil context")
+	}
+	fs2 := *fs
+	fs2.ctx = ctx
+	return &fs2
+}
+
+// Context returns the context bound to fs. It defaults to
+// context.Background.
+func (fs *FileSystem) Context() context.Context {
+	return fs.context()
+}
+
+// context returns the context used for requests made through fs
+func (fs *FileSystem) context() context.Context {
+	if fs.ctx == nil {
+		return context.Background()
+	}
+	return fs.ctx
+}
+
 // cleanPath normalizes a path
 func (fs *FileSystem) cleanPath(name string) string {
 	// Normalize Windows backslashes to forward slashes
@@ -61,12 +102,13 @@ func (fs *FileSystem) cleanPath(name string) string {
 	return path.Clean(path.Join(fs.cwd, name))
 }
 
-// OpenFile opens a file with the specified flags and permissions
+// OpenFile opens a file with the specified flags and permissions. With
+// O_LOCK, the file is locked exclusively until it is closed.
 func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
 	name = fs.cleanPath(name)
 
 	// Check if file exists
-	info, err := fs.client.stat(name)
+	info, err := fs.client.stat(fs.context(), name)
 	if err != nil {
 		// File doesn't exist
 		if !os.IsNotExist(err) {
@@ -77,17 +119,6 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 		if flag&os.O_CREATE == 0 {
 			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
 		}
-
-		// Create empty file
-		if err := fs.client.put(name, strings.NewReader("")); err != nil {
-			return nil, err
-		}
-
-		// Get info for the new file
-		info, err = fs.client.stat(name)
-		if err != nil {
-			return nil, err
-		}
 	} else {
 		// File exists
 		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
@@ -98,12 +129,35 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 		if info.IsDir() && (flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0) {
 			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
 		}
+	}
 
-		// Truncate if requested
-		if flag&os.O_TRUNC != 0 && !info.IsDir() {
-			if err := fs.client.put(name, strings.NewReader("")); err != nil {
-				return nil, err
+	// An exclusive create comes before the lock, because a LOCK of a missing
+	// file creates it (RFC 4918, section 9.10.4) and the create would then
+	// fail. Otherwise lock before creating or truncating, so that nobody
+	// else sees the intermediate state.
+	exclusive := info == nil && flag&os.O_EXCL != 0
+	if exclusive {
+		info, err = fs.prepareOpen(name, flag, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lock *Lock
+	if flag&O_LOCK != 0 {
+		lock, err = fs.client.lock(fs.context(), name, LockOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !exclusive {
+		info, err = fs.prepareOpen(name, flag, info)
+		if err != nil {
+			if lock != nil {
+				lock.Unlock()
 			}
+			return nil, err
 		}
 	}
 
@@ -112,6 +166,8 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 		path: name,
 		flag: flag,
 		info: info,
+		etag: etagOf(info),
+		lock: lock,
 	}
 
 	// Set initial offset for append mode
@@ -122,6 +178,29 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 	return f, nil
 }
 
+// prepareOpen creates or truncates the file as requested by flag and returns
+// its current info. info is nil if the file does not exist yet.
+func (fs *FileSystem) prepareOpen(name string, flag int, info os.FileInfo) (os.FileInfo, error) {
+	create := info == nil
+	truncate := info != nil && flag&os.O_TRUNC != 0 && !info.IsDir()
+	if !create && !truncate {
+		return info, nil
+	}
+
+	// Create an empty file, or truncate the version that was just looked at.
+	// With O_EXCL, creation fails if someone else created the file meanwhile.
+	pre := precondition{ifNoneMatch: create && flag&os.O_EXCL != 0}
+	if truncate {
+		pre = ifMatch(etagOf(info))
+	}
+	if _, err := fs.client.putCond(fs.context(), name, strings.NewReader(""), pre); err != nil {
+		return nil, err
+	}
+
+	// Refresh the info so it describes the now empty file
+	return fs.client.stat(fs.context(), name)
+}
+
 // Open opens a file for reading
 func (fs *FileSystem) Open(name string) (absfs.File, error) {
 	return fs.OpenFile(name, os.O_RDONLY, 0)
@@ -135,7 +214,7 @@ func (fs *FileSystem) Create(name string) (absfs.File, error) {
 // Mkdir creates a directory
 func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
 	name = fs.cleanPath(name)
-	return fs.client.mkcol(name)
+	return fs.client.mkcol(fs.context(), name)
 }
 
 // MkdirAll creates a directory and all parent directories
@@ -143,7 +222,7 @@ func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
 	name = fs.cleanPath(name)
 
 	// Check if it already exists
-	if info, err := fs.client.stat(name); err == nil {
+	if info, err := fs.client.stat(fs.context(), name); err == nil {
 		if info.IsDir() {
 			return nil
 		}
@@ -159,32 +238,91 @@ func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
 	}
 
 	// Create the directory
-	return fs.client.mkcol(name)
+	return fs.client.mkcol(fs.context(), name)
 }
 
 // Remove removes a file or empty directory
 func (fs *FileSystem) Remove(name string) error {
 	name = fs.cleanPath(name)
-	return fs.client.delete(name)
+	return fs.client.delete(fs.context(), name)
 }
 
 // RemoveAll removes a path and all children
 func (fs *FileSystem) RemoveAll(name string) error {
 	name = fs.cleanPath(name)
-	return fs.client.delete(name)
+	return fs.client.delete(fs.context(), name)
 }
 
-// Rename renames (moves) a file or directory
+// Rename renames (moves) a file or directory. Like os.Rename, it replaces an
+// existing file at newpath, or an empty directory if oldpath is a directory.
+// Use RenameNoReplace to fail when newpath exists.
 func (fs *FileSystem) Rename(oldpath, newpath string) error {
 	oldpath = fs.cleanPath(oldpath)
 	newpath = fs.cleanPath(newpath)
-	return fs.client.move(oldpath, newpath)
+	ctx := fs.context()
+
+	if oldpath == newpath {
+		_, err := fs.client.stat(ctx, oldpath)
+		return err
+	}
+
+	// Overwrite: T deletes whatever is at newpath, so decide from the
+	// server's current state rather than the metadata cache
+	target, err := fs.client.statUncached(ctx, newpath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if target != nil && target.IsDir() {
+		if err := fs.checkReplaceDir(ctx, oldpath, newpath); err != nil {
+			return err
+		}
+	} else if target != nil {
+		source, err := fs.client.statUncached(ctx, oldpath)
+		if err != nil {
+			return err
+		}
+		if source.IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.ENOTDIR}
+		}
+	}
+
+	return fs.client.move(ctx, oldpath, newpath, true)
+}
+
+// checkReplaceDir refuses to replace the directory newpath unless oldpath is
+// a directory too and newpath is empty, as rename(2) does. WebDAV MOVE with
+// Overwrite would delete the whole destination tree instead.
+func (fs *FileSystem) checkReplaceDir(ctx context.Context, oldpath, newpath string) error {
+	source, err := fs.client.statUncached(ctx, oldpath)
+	if err != nil {
+		return err
+	}
+	if !source.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EISDIR}
+	}
+
+	empty, err := fs.client.isEmptyDir(ctx, newpath)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.ENOTEMPTY}
+	}
+	return nil
+}
+
+// RenameNoReplace renames (moves) a file or directory like Rename, but fails
+// with an error matching os.ErrExist if newpath already exists
+func (fs *FileSystem) RenameNoReplace(oldpath, newpath string) error {
+	oldpath = fs.cleanPath(oldpath)
+	newpath = fs.cleanPath(newpath)
+	return fs.client.move(fs.context(), oldpath, newpath, false)
 }
 
 // Stat returns file information
 func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
 	name = fs.cleanPath(name)
-	return fs.client.stat(name)
+	return fs.client.stat(fs.context(), name)
 }
 
 // Chmod changes file permissions (limited WebDAV support)
@@ -192,7 +330,7 @@ func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
 	name = fs.cleanPath(name)
 	// Most WebDAV servers don't support chmod
 	// Check if file exists
-	_, err := fs.client.stat(name)
+	_, err := fs.client.stat(fs.context(), name)
 	return err
 }
 
@@ -201,14 +339,14 @@ func (fs *FileSystem) Chown(name string, uid, gid int) error {
 	name = fs.cleanPath(name)
 	// WebDAV doesn't support chown
 	// Check if file exists
-	_, err := fs.client.stat(name)
+	_, err := fs.client.stat(fs.context(), name)
 	return err
 }
 
 // Chtimes changes file modification time
 func (fs *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	name = fs.cleanPath(name)
-	return fs.client.proppatch(name, mtime)
+	return fs.client.proppatch(fs.context(), name, mtime)
 }
 
 // Chdir changes the current working directory
@@ -216,7 +354,7 @@ func (fs *FileSystem) Chdir(dir string) error {
 	dir = fs.cleanPath(dir)
 
 	// Check if directory exists
-	info, err := fs.client.stat(dir)
+	info, err := fs.client.stat(fs.context(), dir)
 	if err != nil {
 		return err
 	}
@@ -239,9 +377,14 @@ func (fs *FileSystem) TempDir() string {
 	return fs.tempDir
 }
 
-// Truncate truncates a file to a specified size
+// Truncate changes the size of a file. Shrinking streams the first size
+// bytes back to the server and growing streams the content followed by
+// zeros, so memory use does not depend on the file size. Servers with a
+// partial update dialect (see Config.PartialUpdate) only receive the zeros
+// appended when growing.
 func (fs *FileSystem) Truncate(name string, size int64) error {
 	name = fs.cleanPath(name)
+	ctx := fs.context()
 
 	if size < 0 {
 		return &os.PathError{Op: "truncate", Path: name, Err: os.ErrInvalid}
@@ -249,15 +392,16 @@ func (fs *FileSystem) Truncate(name string, size int64) error {
 
 	if size == 0 {
 		// Truncate to zero by uploading empty content
-		return fs.client.put(name, strings.NewReader(""))
+		return fs.client.put(ctx, name, strings.NewReader(""))
 	}
 
-	// For non-zero sizes, download current content, truncate, and re-upload
-	// First check current file size
-	info, err := fs.client.stat(name)
+	info, err := fs.client.stat(ctx, name)
 	if err != nil {
 		return err
 	}
+	if info.IsDir() {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EISDIR}
+	}
 
 	currentSize := info.Size()
 	if currentSize == size {
@@ -265,40 +409,50 @@ func (fs *FileSystem) Truncate(name string, size int64) error {
 		return nil
 	}
 
-	if currentSize < size {
-		// Expanding the file - download, pad with zeros, and re-upload
-		rc, err := fs.client.get(name, 0)
-		if err != nil {
-			return err
-		}
-
-		currentData, err := io.ReadAll(rc)
-		rc.Close()
-		if err != nil {
-			return &os.PathError{Op: "truncate", Path: name, Err: err}
+	if currentSize > 0 && currentSize < size {
+		if dialect := fs.client.partialDialect(ctx); dialect != PartialUpdateNone {
+			_, _, err := fs.client.patchRanges(ctx, name, dialect, zeros{}, []byteRange{{currentSize, size}}, currentSize, ifMatch(etagOf(info)))
+			if err != errPartialRejected {
+				return err
+			}
 		}
-
-		// Pad with zeros
-		newData := make([]byte, size)
-		copy(newData, currentData)
-
-		return fs.client.put(name, bytes.NewReader(newData))
 	}
 
-	// Shrinking the file - download first 'size' bytes and re-upload
-	rc, err := fs.client.get(name, 0)
+	// Stream the part of the content that is kept, padded with zeros
+	body, etag, err := fs.client.getRange(ctx, name, 0, min(currentSize, size))
 	if err != nil {
 		return err
 	}
+	defer body.Close()
+	if etag == "" {
+		etag = etagOf(info)
+	}
 
-	truncatedData := make([]byte, size)
-	n, err := io.ReadFull(rc, truncatedData)
-	rc.Close()
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return &os.PathError{Op: "truncate", Path: name, Err: err}
+	upload := fs.client.startStreamUpload(ctx, name, ifMatch(etag))
+	if _, err := io.Copy(upload, io.LimitReader(io.MultiReader(body, zeros{}), size)); err != nil {
+		// A failed download must not replace the file with a prefix of it
+		upload.abort(err)
+		if _, ok := err.(*os.PathError); ok {
+			// The upload itself failed
+			return err
+		}
+		return &os.PathError{Op: "truncate", Path: name, Err: contextError(ctx, err)}
 	}
+	_, err = upload.finish()
+	return err
+}
+
+// zeros is an endless source of zero bytes
+type zeros struct{}
 
-	return fs.client.put(name, bytes.NewReader(truncatedData[:n]))
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func (zeros) ReadAt(p []byte, off int64) (int, error) {
+	clear(p)
+	return len(p), nil
 }
 
 // ReadFile reads the entire file and returns its contents.
@@ -321,18 +475,21 @@ func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
 		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrInvalid}
 	}
 
-	data := make([]byte, info.Size())
-	n, err := f.Read(data)
-	if err != nil && err != io.EOF {
+	buf := bytes.NewBuffer(make([]byte, 0, info.Size()))
+	if _, err := io.Copy(buf, f); err != nil {
 		return nil, err
 	}
 
-	return data[:n], nil
+	return buf.Bytes(), nil
 }
 
 // WriteFile writes data to a file
 func (fs *FileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
-	f, err := fs.Create(name)
+	if err := fs.client.checkQuota(fs.context(), fs.cleanPath(name), int64(len(data))); err != nil {
+		return err
+	}
+
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
@@ -367,7 +524,7 @@ func (d dirEntry) Info() (os.FileInfo, error) { return d.info, nil }
 func (fs *FileSystem) ReadDir(name string) ([]iofs.DirEntry, error) {
 	name = fs.cleanPath(name)
 
-	infos, err := fs.client.readDir(name)
+	infos, err := fs.client.readDir(fs.context(), name)
 	if err != nil {
 		return nil, err
 	}
@@ -1,12 +1,22 @@
 package webdavfs
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"golang.org/x/net/webdav"
 )
 
 // mockWebDAVServer creates a mock WebDAV server for testing
@@ -379,8 +389,8 @@ func TestConfig_SetDefaults(t *testing.T) {
 		t.Errorf("Expected timeout 30s, got %v", config.Timeout)
 	}
 
-	if config.HTTPClient == nil {
-		t.Error("Expected HTTPClient to be set")
+	if config.HTTPClient != nil {
+		t.Error("Expected HTTPClient to be left unset")
 	}
 
 	if config.TempDir != "/tmp" {
@@ -388,6 +398,56 @@ func TestConfig_SetDefaults(t *testing.T) {
 	}
 }
 
+func TestDefaultHTTPClient_CustomDefaultTransport(t *testing.T) {
+	saved := http.DefaultTransport
+	defer func() { http.DefaultTransport = saved }()
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("not used")
+	})
+
+	client := defaultHTTPClient(30 * time.Second)
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected *http.Transport, got %T", client.Transport)
+	}
+	if transport.ResponseHeaderTimeout != 30*time.Second {
+		t.Errorf("Expected response header timeout 30s, got %v", transport.ResponseHeaderTimeout)
+	}
+}
+
+func TestNew_ReusesConfig(t *testing.T) {
+	config := &Config{
+		URL: "https://example.com",
+		TLS: &TLSConfig{ServerName: "dav.example.com"},
+	}
+	first, err := New(config)
+	if err != nil {
+		t.Fatalf("First New() error = %v", err)
+	}
+	second, err := New(config)
+	if err != nil {
+		t.Fatalf("Second New() with the same Config error = %v", err)
+	}
+	if config.HTTPClient != nil {
+		t.Error("New() stored its HTTP client in the caller's Config")
+	}
+
+	// Each filesystem has a transport of its own
+	t1 := first.client.httpClient.Transport.(*http.Transport)
+	t2 := second.client.httpClient.Transport.(*http.Transport)
+	if t1 == t2 {
+		t.Error("filesystems created from one Config share a transport")
+	}
+	if t2.TLSClientConfig == nil || t2.TLSClientConfig.ServerName != "dav.example.com" {
+		t.Errorf("TLSClientConfig = %+v, want ServerName from Config.TLS", t2.TLSClientConfig)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
 func TestFile_WriteAndClose(t *testing.T) {
 	server := mockWebDAVServer()
 	defer server.Close()
@@ -466,6 +526,120 @@ func TestFileSystem_Rename(t *testing.T) {
 	}
 }
 
+func TestFileSystem_RenameReplace(t *testing.T) {
+	server := newLockingServer(t)
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	write := func(name, data string) {
+		t.Helper()
+		if err := fs.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile(%s) error = %v", name, err)
+		}
+	}
+
+	// Atomic save: write a temporary file and rename it over the original
+	write("/doc.txt", "old")
+	write("/doc.txt.tmp", "new")
+	if err := fs.Rename("/doc.txt.tmp", "/doc.txt"); err != nil {
+		t.Fatalf("Rename() onto existing file error = %v", err)
+	}
+	got, err := fs.ReadFile("/doc.txt")
+	if err != nil || string(got) != "new" {
+		t.Errorf("ReadFile() = %q, %v; want %q", got, err, "new")
+	}
+
+	write("/other.txt", "other")
+	if err := fs.RenameNoReplace("/other.txt", "/doc.txt"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("RenameNoReplace() onto existing file error = %v, want os.ErrExist", err)
+	}
+	if err := fs.RenameNoReplace("/other.txt", "/moved.txt"); err != nil {
+		t.Errorf("RenameNoReplace() error = %v", err)
+	}
+
+	for _, dir := range []string{"/a", "/empty", "/full"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir(%s) error = %v", dir, err)
+		}
+	}
+	write("/a/x.txt", "x")
+	write("/full/keep.txt", "keep")
+
+	if err := fs.Rename("/a", "/full"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Rename() onto non-empty directory error = %v, want ENOTEMPTY", err)
+	}
+	if _, err := fs.Stat("/full/keep.txt"); err != nil {
+		t.Errorf("non-empty directory was modified: %v", err)
+	}
+	if err := fs.Rename("/doc.txt", "/full"); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Rename() of file onto directory error = %v, want EISDIR", err)
+	}
+	if err := fs.Rename("/a", "/doc.txt"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("Rename() of directory onto file error = %v, want ENOTDIR", err)
+	}
+
+	if err := fs.Rename("/a", "/empty"); err != nil {
+		t.Fatalf("Rename() onto empty directory error = %v", err)
+	}
+	if _, err := fs.Stat("/empty/x.txt"); err != nil {
+		t.Errorf("Stat() after directory rename error = %v", err)
+	}
+
+	if err := fs.Rename("/doc.txt", "/doc.txt"); err != nil {
+		t.Errorf("Rename() onto itself error = %v", err)
+	}
+}
+
+func TestFileSystem_RenameReplaceStaleCache(t *testing.T) {
+	server := newLockingServer(t)
+
+	fs, err := New(&Config{URL: server.URL, MetadataCache: &MetadataCacheConfig{TTL: time.Minute}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	other, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	for _, dir := range []string{"/src", "/dst"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir(%s) error = %v", dir, err)
+		}
+	}
+	if entries, err := fs.ReadDir("/dst"); err != nil || len(entries) != 0 {
+		t.Fatalf("ReadDir() = %d entries, %v; want an empty directory", len(entries), err)
+	}
+
+	// Another client fills the directory while its empty listing is cached
+	if err := other.WriteFile("/dst/keep.txt", []byte("keep"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := fs.Rename("/src", "/dst"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Rename() onto a directory filled meanwhile error = %v, want ENOTEMPTY", err)
+	}
+	if got, err := other.ReadFile("/dst/keep.txt"); err != nil || string(got) != "keep" {
+		t.Errorf("ReadFile() = %q, %v; the destination was replaced", got, err)
+	}
+
+	// Likewise for a directory created where none was cached
+	if _, err := fs.Stat("/new"); !os.IsNotExist(err) {
+		t.Fatalf("Stat() error = %v, want not exist", err)
+	}
+	if err := other.Mkdir("/new", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := other.WriteFile("/new/keep.txt", []byte("keep"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := fs.Rename("/src", "/new"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Rename() onto a directory created meanwhile error = %v, want ENOTEMPTY", err)
+	}
+}
+
 func TestFileSystem_Remove(t *testing.T) {
 	server := mockWebDAVServer()
 	defer server.Close()
@@ -692,10 +866,19 @@ func TestFile_Truncate(t *testing.T) {
 		t.Errorf("Truncate(0) error = %v", err)
 	}
 
-	// Truncate to non-zero should fail
+	// Growing pads with zeros
 	err = f.Truncate(5)
+	if err != nil {
+		t.Errorf("Truncate(5) error = %v", err)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 5 {
+		t.Errorf("Stat() after Truncate(5) = %v, %v; want size 5", info, err)
+	}
+
+	// Negative sizes are rejected
+	err = f.Truncate(-1)
 	if err == nil {
-		t.Error("Truncate(5) expected error, got nil")
+		t.Error("Truncate(-1) expected error, got nil")
 	}
 }
 
@@ -762,6 +945,101 @@ func TestFileSystem_Truncate(t *testing.T) {
 	}
 }
 
+func TestFileSystem_TruncateStreaming(t *testing.T) {
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	var mu sync.Mutex
+	var ranges []string
+	var failGet bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			fail := failGet
+			mu.Unlock()
+			if fail {
+				// Drop the connection halfway through the body
+				w.Header().Set("Content-Length", "1000")
+				w.Write([]byte("partial"))
+				panic(http.ErrAbortHandler)
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	content := strings.Repeat("0123456789", 10)
+	if err := fs.WriteFile("/test.txt", []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// Shrinking only downloads the part that is kept
+	if err := fs.Truncate("/test.txt", 30); err != nil {
+		t.Fatalf("Truncate(30) error = %v", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=0-29" {
+		t.Errorf("GET ranges = %q, want [bytes=0-29]", ranges)
+	}
+	if data, _ := fs.ReadFile("/test.txt"); string(data) != content[:30] {
+		t.Errorf("After Truncate(30): content = %q", data)
+	}
+
+	// Growing pads with zeros
+	if err := fs.Truncate("/test.txt", 40); err != nil {
+		t.Fatalf("Truncate(40) error = %v", err)
+	}
+	if data, _ := fs.ReadFile("/test.txt"); string(data) != content[:30]+strings.Repeat("\x00", 10) {
+		t.Errorf("After Truncate(40): content = %q", data)
+	}
+
+	// A failed download leaves the file unchanged
+	mu.Lock()
+	failGet = true
+	mu.Unlock()
+	if err := fs.Truncate("/test.txt", 20); err == nil {
+		t.Error("Truncate() with failing download expected error")
+	}
+	mu.Lock()
+	failGet = false
+	mu.Unlock()
+	if data, _ := fs.ReadFile("/test.txt"); len(data) != 40 {
+		t.Errorf("After failed Truncate: size = %d, want 40", len(data))
+	}
+
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := fs.Truncate("/dir", 10); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Truncate() on a directory error = %v, want EISDIR", err)
+	}
+}
+
+func TestFileSystem_TruncatePartialUpdate(t *testing.T) {
+	server := newPartialServer(t, PartialUpdateSabre)
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := fs.WriteFile("/test.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	server.takeRequests()
+
+	// Growing only sends the zeros
+	if err := fs.Truncate("/test.txt", 8); err != nil {
+		t.Fatalf("Truncate(8) error = %v", err)
+	}
+	if got, want := server.takeRequests(), []string{"PATCH append"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	if data, _ := fs.ReadFile("/test.txt"); string(data) != "hello\x00\x00\x00" {
+		t.Errorf("After Truncate(8): content = %q", data)
+	}
+}
+
 func TestFileSystem_MkdirAll(t *testing.T) {
 	// Create a custom mock server that handles the MkdirAll scenario properly
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1266,6 +1544,96 @@ func TestFileInfo(t *testing.T) {
 	}
 }
 
+func TestFileInfo_Sys(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requested = string(body)
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:response>
+    <d:href>/files/report%20final.pdf</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:displayname>Report</d:displayname>
+        <d:getcontentlength>42</d:getcontentlength>
+        <d:getetag>"5f2a"</d:getetag>
+        <d:getcontenttype>application/pdf</d:getcontenttype>
+        <d:creationdate>2024-03-01T10:00:00Z</d:creationdate>
+        <d:resourcetype/>
+        <d:lockdiscovery>
+          <d:activelock>
+            <d:locktype><d:write/></d:locktype>
+            <d:lockscope><d:exclusive/></d:lockscope>
+            <d:depth>infinity</d:depth>
+            <d:owner>alice</d:owner>
+            <d:timeout>Second-300</d:timeout>
+            <d:locktoken><d:href>opaquelocktoken:1234</d:href></d:locktoken>
+            <d:lockroot><d:href>/files/report%20final.pdf</d:href></d:lockroot>
+          </d:activelock>
+        </d:lockdiscovery>
+        <d:supportedlock>
+          <d:lockentry><d:lockscope><d:exclusive/></d:lockscope><d:locktype><d:write/></d:locktype></d:lockentry>
+          <d:lockentry><d:lockscope><d:shared/></d:lockscope><d:locktype><d:write/></d:locktype></d:lockentry>
+        </d:supportedlock>
+        <oc:fileid>00000123</oc:fileid>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`))
+	}))
+	defer server.Close()
+
+	fileID := xml.Name{Space: "http://owncloud.org/ns", Local: "fileid"}
+	fs, err := New(&Config{URL: server.URL, ExtraProperties: []xml.Name{fileID}})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	info, err := fs.Stat("/report.pdf")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !strings.Contains(requested, `<x0:fileid xmlns:x0="http://owncloud.org/ns"/>`) {
+		t.Errorf("PROPFIND body does not request the extra property:\n%s", requested)
+	}
+
+	res, ok := info.Sys().(*ResourceInfo)
+	if !ok {
+		t.Fatalf("Sys() = %T, want *ResourceInfo", info.Sys())
+	}
+	if res.Href != "/files/report%20final.pdf" {
+		t.Errorf("Href = %q", res.Href)
+	}
+	if res.ETag != `"5f2a"` || res.ContentType != "application/pdf" || res.DisplayName != "Report" {
+		t.Errorf("ETag, ContentType, DisplayName = %q, %q, %q", res.ETag, res.ContentType, res.DisplayName)
+	}
+	if want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC); !res.CreationTime.Equal(want) {
+		t.Errorf("CreationTime = %v, want %v", res.CreationTime, want)
+	}
+
+	wantLock := ActiveLock{
+		Token:     "opaquelocktoken:1234",
+		Owner:     "alice",
+		Root:      "/files/report%20final.pdf",
+		Scope:     "exclusive",
+		Recursive: true,
+		Timeout:   300 * time.Second,
+	}
+	if len(res.Locks) != 1 || res.Locks[0] != wantLock {
+		t.Errorf("Locks = %+v, want [%+v]", res.Locks, wantLock)
+	}
+	wantEntries := []LockEntry{{"exclusive", "write"}, {"shared", "write"}}
+	if len(res.SupportedLocks) != 2 || res.SupportedLocks[0] != wantEntries[0] || res.SupportedLocks[1] != wantEntries[1] {
+		t.Errorf("SupportedLocks = %+v, want %+v", res.SupportedLocks, wantEntries)
+	}
+	if got := res.Properties[fileID]; got != "00000123" {
+		t.Errorf("Properties[fileid] = %q, want %q", got, "00000123")
+	}
+}
+
 func TestInterfaceCompliance(t *testing.T) {
 	// This is a compile-time check that FileSystem implements absfs.FileSystem
 	// The actual check is done in the source files with:
@@ -1632,3 +2000,390 @@ func TestFile_WriteOnDir(t *testing.T) {
 		t.Error("OpenFile directory for writing should fail")
 	}
 }
+
+func TestFileSystem_WithContext(t *testing.T) {
+	server := mockWebDAVServer()
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfs := fs.WithContext(ctx)
+	if cfs.Context() != ctx {
+		t.Error("Context() did not return the bound context")
+	}
+	if fs.Context() != context.Background() {
+		t.Error("WithContext modified the original filesystem")
+	}
+
+	if _, err := cfs.Stat("/test.txt"); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	cancel()
+
+	_, err = cfs.Stat("/test.txt")
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("Stat() after cancel: expected *os.PathError, got %T (%v)", err, err)
+	}
+	if pathErr.Err != context.Canceled {
+		t.Errorf("Stat() after cancel: expected context.Canceled, got %v", pathErr.Err)
+	}
+
+	// The original filesystem is unaffected
+	if _, err := fs.Stat("/test.txt"); err != nil {
+		t.Errorf("Stat() on original filesystem error = %v", err)
+	}
+}
+
+func TestFile_ReadContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PROPFIND":
+			handlePropfind(w, r)
+		case "GET":
+			// Send part of the body, then stall until the client goes away
+			w.WriteHeader(200)
+			w.Write([]byte("Hello"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		}
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f, err := fs.WithContext(ctx).Open("/test.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	cancel()
+
+	_, err = f.Read(buf)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Read() after cancel: expected context.Canceled, got %v", err)
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		t.Errorf("Read() after cancel: expected *os.PathError, got %T", err)
+	}
+}
+
+func TestFile_StreamingWrite(t *testing.T) {
+	var mu sync.Mutex
+	var puts []int64
+
+	mock := newStatefulMockServer()
+	defer mock.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			mu.Lock()
+			puts = append(puts, r.ContentLength)
+			mu.Unlock()
+		}
+		proxyTo(mock.URL, w, r)
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	f, err := fs.OpenFile("/stream.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+
+	var want bytes.Buffer
+	for i := 0; i < 100; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 1000)
+		want.Write(chunk)
+		if _, err := f.Write(chunk); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	// Seeking backwards is not possible once the upload has started
+	if _, err := f.Seek(0, io.SeekStart); err == nil {
+		t.Error("Seek() during streaming upload expected error")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	got, err := fs.ReadFile("/stream.bin")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("uploaded content mismatch: got %d bytes, want %d", len(got), want.Len())
+	}
+
+	// The data arrived as a single PUT with a streamed (unknown length) body
+	mu.Lock()
+	defer mu.Unlock()
+	if len(puts) != 2 || puts[1] != -1 {
+		t.Errorf("expected create PUT followed by one streamed PUT, got content lengths %v", puts)
+	}
+}
+
+func TestFile_StreamingWriteServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PROPFIND":
+			handlePropfind(w, r)
+		case "PUT":
+			io.Copy(io.Discard, r.Body)
+			if r.ContentLength == -1 {
+				w.WriteHeader(http.StatusInsufficientStorage)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	f, err := fs.OpenFile("/full.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	f.Write([]byte("data"))
+	if err := f.Close(); err == nil {
+		t.Error("Close() expected error from server response")
+	}
+}
+
+func TestFile_ReadFromWriteTo(t *testing.T) {
+	server := newStatefulMockServer()
+	defer server.Close()
+
+	fs, err := New(&Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	f, err := fs.OpenFile("/copy.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	n, err := io.Copy(f, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("io.Copy() to remote error = %v", err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("io.Copy() to remote copied %d bytes, want %d", n, len(content))
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	f, err = fs.Open("/copy.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, f); err != nil {
+		t.Fatalf("io.Copy() from remote error = %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("downloaded content mismatch: got %d bytes, want %d", got.Len(), len(content))
+	}
+}
+
+// proxyTo forwards r to the server at target, so tests can observe the
+// requests a stateful mock server receives
+func proxyTo(target string, w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequest(r.Method, target+r.URL.RequestURI(), r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header = r.Header.Clone()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func TestFile_ReadModifyWrite(t *testing.T) {
+	server := newStatefulMockServer()
+	defer server.Close()
+
+	for _, threshold := range []int64{0, 1} {
+		fs, err := New(&Config{URL: server.URL, SpoolThreshold: threshold})
+		if err != nil {
+			t.Fatalf("Failed to create filesystem: %v", err)
+		}
+
+		if err := fs.WriteFile("/rmw.txt", []byte("Hello World"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+
+		t.Run("overwrite in place", func(t *testing.T) {
+			f, err := fs.OpenFile("/rmw.txt", os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			if _, err := f.Seek(6, io.SeekStart); err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			if _, err := f.Write([]byte("WebDAV!")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			// Reads see the local modifications before they are written back
+			buf := make([]byte, 5)
+			if _, err := f.ReadAt(buf, 0); err != nil {
+				t.Fatalf("ReadAt() error = %v", err)
+			}
+			if string(buf) != "Hello" {
+				t.Errorf("ReadAt() = %q, want %q", buf, "Hello")
+			}
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if info.Size() != 13 {
+				t.Errorf("Stat().Size() = %d, want 13", info.Size())
+			}
+
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			data, _ := fs.ReadFile("/rmw.txt")
+			if string(data) != "Hello WebDAV!" {
+				t.Errorf("content = %q, want %q", data, "Hello WebDAV!")
+			}
+		})
+
+		t.Run("append", func(t *testing.T) {
+			f, err := fs.OpenFile("/rmw.txt", os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			if _, err := f.Write([]byte(" Bye")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			// Seeking does not affect where appended data goes
+			f.Seek(0, io.SeekStart)
+			if _, err := f.Write([]byte("!")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if _, err := f.WriteAt([]byte("x"), 0); err == nil {
+				t.Error("WriteAt() in append mode expected error")
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			data, _ := fs.ReadFile("/rmw.txt")
+			if string(data) != "Hello WebDAV! Bye!" {
+				t.Errorf("content = %q, want %q", data, "Hello WebDAV! Bye!")
+			}
+		})
+
+		t.Run("sync then write", func(t *testing.T) {
+			f, err := fs.OpenFile("/rmw.txt", os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			f.WriteAt([]byte("J"), 0)
+			if err := f.Sync(); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			f.WriteAt([]byte("?"), 17)
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			data, _ := fs.ReadFile("/rmw.txt")
+			if string(data) != "Jello WebDAV! Bye?" {
+				t.Errorf("content = %q, want %q", data, "Jello WebDAV! Bye?")
+			}
+		})
+
+		t.Run("write past end", func(t *testing.T) {
+			f, err := fs.OpenFile("/rmw.txt", os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			f.WriteAt([]byte("end"), 20)
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			data, _ := fs.ReadFile("/rmw.txt")
+			want := "Jello WebDAV! Bye?\x00\x00end"
+			if string(data) != want {
+				t.Errorf("content = %q, want %q", data, want)
+			}
+		})
+	}
+}
+
+func TestWriteBack_Truncate(t *testing.T) {
+	wb, err := newWriteBack(10, 1<<20, "")
+	if err != nil {
+		t.Fatalf("newWriteBack() error = %v", err)
+	}
+	defer wb.close()
+
+	wb.writeAt([]byte("XY"), 2)
+	wb.truncate(4)
+	wb.truncate(8)
+
+	if err := wb.load(strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	got := make([]byte, 8)
+	if _, err := wb.readAt(got, 0); err != nil {
+		t.Fatalf("readAt() error = %v", err)
+	}
+	want := "01XY\x00\x00\x00\x00"
+	if string(got) != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,384 @@
+package webdavfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+)
+
+// spool holds the local working copy of a file being modified
+type spool interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Close() error
+}
+
+// memSpool is an in-memory spool for small files
+type memSpool struct {
+	data []byte
+}
+
+func (s *memSpool) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *memSpool) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(s.data)) {
+		s.Truncate(end)
+	}
+	return copy(s.data[off:], p), nil
+}
+
+func (s *memSpool) Truncate(size int64) error {
+	if size <= int64(len(s.data)) {
+		s.data = s.data[:size]
+		return nil
+	}
+	if size <= int64(cap(s.data)) {
+		// Bytes past the old length may hold stale data
+		old := len(s.data)
+		s.data = s.data[:size]
+		clear(s.data[old:])
+		return nil
+	}
+	grown := make([]byte, size, size+size/4)
+	copy(grown, s.data)
+	s.data = grown
+	return nil
+}
+
+func (s *memSpool) Close() error {
+	s.data = nil
+	return nil
+}
+
+// fileSpool is a spool backed by a local temporary file for large files
+type fileSpool struct {
+	*os.File
+}
+
+func newFileSpool(dir string) (*fileSpool, error) {
+	f, err := os.CreateTemp(dir, "webdavfs-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileSpool{f}, nil
+}
+
+func (s *fileSpool) Close() error {
+	err := s.File.Close()
+	os.Remove(s.Name())
+	return err
+}
+
+// byteRange is a half-open range of byte offsets [start, end)
+type byteRange struct {
+	start, end int64
+}
+
+// writeBack tracks the local modifications of an open file until they are
+// written back to the server.
+//
+// The spool holds authoritative data for the dirty ranges and for anything
+// past the end of the original content still visible (base). The rest of the
+// original content stays on the server until it is needed, at which point
+// load fills it in around the dirty ranges.
+type writeBack struct {
+	spool     spool
+	size      int64       // Current logical size of the file
+	remote    int64       // Size of the file on the server
+	base      int64       // Length of the original content still visible
+	loaded    bool        // Whether the spool holds all of [0, base)
+	dirty     []byteRange // Sorted, non-overlapping ranges written locally
+	changed   bool        // Whether there is anything to write back
+	threshold int64       // Size above which the spool moves to disk
+	dir       string      // Directory for spool files
+}
+
+// newWriteBack creates the write-back state for a file whose current content
+// on the server is size bytes long
+func newWriteBack(size, threshold int64, dir string) (*writeBack, error) {
+	wb := &writeBack{
+		size:      size,
+		remote:    size,
+		base:      size,
+		loaded:    size == 0,
+		threshold: threshold,
+		dir:       dir,
+	}
+	if size > threshold {
+		s, err := newFileSpool(dir)
+		if err != nil {
+			return nil, err
+		}
+		wb.spool = s
+	} else {
+		wb.spool = &memSpool{}
+	}
+	return wb, nil
+}
+
+// reserve moves the spool to disk once it needs to hold more than the
+// threshold
+func (wb *writeBack) reserve(size int64) error {
+	mem, ok := wb.spool.(*memSpool)
+	if !ok || size <= wb.threshold {
+		return nil
+	}
+	s, err := newFileSpool(wb.dir)
+	if err != nil {
+		return err
+	}
+	if _, err := s.WriteAt(mem.data, 0); err != nil {
+		s.Close()
+		return err
+	}
+	mem.Close()
+	wb.spool = s
+	return nil
+}
+
+// markDirty records [start, end) as written locally
+func (wb *writeBack) markDirty(start, end int64) {
+	if start >= end {
+		return
+	}
+	merged := byteRange{start, end}
+	var out []byteRange
+	for _, r := range wb.dirty {
+		if r.end < merged.start || r.start > merged.end {
+			out = append(out, r)
+			continue
+		}
+		merged.start = min(merged.start, r.start)
+		merged.end = max(merged.end, r.end)
+	}
+	out = append(out, merged)
+	sort.Slice(out, func(i, j int) bool { return out[i].start < out[j].start })
+	wb.dirty = out
+}
+
+// writeAt writes p at off, extending the file if needed
+func (wb *writeBack) writeAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if err := wb.reserve(max(end, wb.size)); err != nil {
+		return 0, err
+	}
+	if off > wb.size {
+		// The gap becomes a hole of zeros
+		if err := wb.spool.Truncate(wb.size); err != nil {
+			return 0, err
+		}
+		wb.markDirty(wb.size, off)
+	}
+	n, err := wb.spool.WriteAt(p, off)
+	wb.markDirty(off, off+int64(n))
+	if off+int64(n) > wb.size {
+		wb.size = off + int64(n)
+	}
+	wb.changed = true
+	return n, err
+}
+
+// truncate changes the logical size of the file
+func (wb *writeBack) truncate(size int64) error {
+	if err := wb.reserve(size); err != nil {
+		return err
+	}
+	if size < wb.base {
+		wb.base = size
+	}
+	// Shrinking first drops stale bytes, so growing reads back zeros
+	if err := wb.spool.Truncate(min(size, wb.size)); err != nil {
+		return err
+	}
+	if err := wb.spool.Truncate(size); err != nil {
+		return err
+	}
+
+	var out []byteRange
+	for _, r := range wb.dirty {
+		if r.start >= size {
+			continue
+		}
+		r.end = min(r.end, size)
+		out = append(out, r)
+	}
+	wb.dirty = out
+	if size > wb.size {
+		wb.markDirty(max(wb.size, wb.base), size)
+	}
+	wb.size = size
+	wb.changed = true
+	return nil
+}
+
+// load fills the parts of [0, base) not overwritten locally with the
+// original content read from r, which must start at offset 0
+func (wb *writeBack) load(r io.Reader) error {
+	if wb.loaded {
+		return nil
+	}
+	if err := wb.reserve(wb.size); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	var pos int64
+	for pos < wb.base {
+		n, err := r.Read(buf[:min(int64(len(buf)), wb.base-pos)])
+		if n > 0 {
+			if werr := wb.fillClean(buf[:n], pos); werr != nil {
+				return werr
+			}
+			pos += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if pos < wb.base {
+		// The file shrank on the server; treat the rest as zeros
+		wb.markDirty(pos, wb.base)
+	}
+	// Make sure the spool covers the whole file
+	if err := wb.spool.Truncate(wb.size); err != nil {
+		return err
+	}
+
+	wb.loaded = true
+	return nil
+}
+
+// fillClean writes the parts of p, located at off, that do not overlap a
+// dirty range
+func (wb *writeBack) fillClean(p []byte, off int64) error {
+	end := off + int64(len(p))
+	pos := off
+	for _, r := range wb.dirty {
+		if r.end <= pos {
+			continue
+		}
+		if r.start >= end {
+			break
+		}
+		if r.start > pos {
+			if _, err := wb.spool.WriteAt(p[pos-off:r.start-off], pos); err != nil {
+				return err
+			}
+		}
+		pos = r.end
+		if pos >= end {
+			return nil
+		}
+	}
+	_, err := wb.spool.WriteAt(p[pos-off:], pos)
+	return err
+}
+
+// readAt reads from the working copy, which must have been loaded
+func (wb *writeBack) readAt(p []byte, off int64) (int, error) {
+	if off >= wb.size {
+		return 0, io.EOF
+	}
+	if rem := wb.size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		n, err := wb.spool.ReadAt(p, off)
+		if err == nil || err == io.EOF {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return wb.spool.ReadAt(p, off)
+}
+
+// flush uploads the working copy if anything changed since the last flush
+// and the precondition holds. It returns the new ETag if the server reported
+// one.
+func (wb *writeBack) flush(ctx context.Context, c *webdavClient, pathStr string, pre precondition) (string, error) {
+	if !wb.changed {
+		return "", nil
+	}
+	if err := c.checkQuota(ctx, pathStr, wb.size); err != nil {
+		return "", err
+	}
+
+	if wb.canPatch() {
+		if dialect := c.partialDialect(ctx); dialect != PartialUpdateNone {
+			etag, next, err := c.patchRanges(ctx, pathStr, dialect, wb.spool, wb.dirty, wb.remote, pre)
+			if err == nil {
+				wb.synced()
+				return etag, nil
+			}
+			if err != errPartialRejected {
+				return "", err
+			}
+			pre = next
+		}
+	}
+
+	if err := wb.ensureLoaded(ctx, c, pathStr); err != nil {
+		return "", err
+	}
+	etag, err := c.upload(ctx, pathStr, wb.spool, wb.size, pre)
+	if err != nil {
+		return "", err
+	}
+	wb.synced()
+	return etag, nil
+}
+
+// canPatch reports whether the changes can be written back as partial
+// updates of the dirty ranges. Partial updates cannot shrink a file, and
+// writing many scattered ranges costs more than uploading the file once.
+func (wb *writeBack) canPatch() bool {
+	return wb.remote > 0 &&
+		wb.size >= wb.remote &&
+		len(wb.dirty) > 0 &&
+		len(wb.dirty) <= maxPartialRanges
+}
+
+// synced records that the server now holds exactly the working copy
+func (wb *writeBack) synced() {
+	wb.base = wb.size
+	wb.remote = wb.size
+	wb.dirty = nil
+	wb.changed = false
+}
+
+// ensureLoaded downloads the original content into the spool if needed
+func (wb *writeBack) ensureLoaded(ctx context.Context, c *webdavClient, pathStr string) error {
+	if wb.loaded {
+		return nil
+	}
+	if wb.base == 0 {
+		wb.loaded = true
+		return nil
+	}
+	body, err := c.get(ctx, pathStr, 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := wb.load(body); err != nil {
+		return &os.PathError{Op: "read", Path: pathStr, Err: contextError(ctx, err)}
+	}
+	return nil
+}
+
+// close releases the spool
+func (wb *writeBack) close() error {
+	return wb.spool.Close()
+}